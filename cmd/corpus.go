@@ -4,13 +4,21 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/htfy96/logalign/internal"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/phuslu/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // corpusCmd represents the corpus command
@@ -31,26 +39,55 @@ var corpusLsCmd = &cobra.Command{
 	Long:  "List all corpus files in the specified directory",
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("All Corpus Files:")
-		for project, corpusFile := range internal.GlobalCorpus {
-			fmt.Printf("Project: %s. File: %s\n", project, corpusFile.GetPath())
+		for _, entry := range internal.GlobalManifest.Entries {
+			fmt.Printf("Project: %s. Version: %q. Built at: %s. Calls: %d. File: %s\n", entry.Project, entry.Version,
+				entry.BuiltAt.Format(time.DateTime), entry.Calls, entry.Path())
+			provenance := entry.Provenance
+			if provenance.LogalignVersion == "" {
+				fmt.Println("    No provenance recorded")
+				continue
+			}
+			head := provenance.Head
+			if head == "" {
+				head = "no git HEAD"
+			} else if provenance.Dirty {
+				head += " (dirty)"
+			}
+			fmt.Printf("    Built by logalign %s from %s at %s. Config hash: %.12s. Content hash: %.12s\n",
+				provenance.LogalignVersion, provenance.RepoRoot, head, provenance.ConfigHash, entry.ContentHash)
 		}
 	},
 }
 
 var corpusCatCmd = &cobra.Command{
-	Use:   "cat {project}",
+	Use:   "cat {project} [version]",
 	Short: "Display the content of a corpus file",
-	Long:  "Display the content of a corpus file for the specified project",
-	Args:  cobra.ExactArgs(1),
+	Long:  "Display the content of a corpus file for the specified project. If version is not provided, the latest built version is displayed",
+	Args:  cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		project := args[0]
-		corpusFile, ok := internal.GlobalCorpus[project]
+		version := ""
+		if len(args) > 1 {
+			version = args[1]
+		}
+		corpus, err := internal.GlobalManifest.Load([]string{project})
+		if err != nil {
+			log.Fatal().Msgf("error reading corpus: %v", err)
+			return
+		}
+		corpusFile, ok := corpus.Lookup(project, version)
 		if !ok {
-			log.Fatal().Msgf("No corpus file found for project: %s\n", project)
+			log.Fatal().Msgf("No corpus file found for project: %s, version: %q\n", project, version)
 			return
 		}
+		filePath := corpusFile.GetPath()
+		for _, entry := range internal.GlobalManifest.Entries {
+			if entry.Project == project && entry.Version == corpusFile.Version {
+				filePath = entry.Path()
+			}
+		}
 		fmt.Printf("Project: %s\n", project)
-		fmt.Printf("File: %s\n", corpusFile.GetPath())
+		fmt.Printf("File: %s\n", filePath)
 		fmt.Println(corpusFile.String())
 	},
 }
@@ -86,8 +123,22 @@ var corpusNewConfigCmd = &cobra.Command{
 	},
 }
 
+var corpusMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert corpus files of earlier releases to the current format",
+	Long:  "Convert the JSON corpus files written by earlier releases to the current compressed format, and add them to the manifest",
+	Run: func(cmd *cobra.Command, args []string) {
+		migrated, err := internal.MigrateCorpus()
+		if err != nil {
+			log.Fatal().Msgf("error migrating corpus: %v", err)
+			return
+		}
+		fmt.Printf("Migrated %d corpus files in %s\n", migrated, internal.CorpusDir)
+	},
+}
+
 var corpusBuildCmd = &cobra.Command{
-	Use:   "build",
+	Use:   "build [repo path]",
 	Short: "Build the corpus",
 	Long:  "Build the corpus based on the current logcall definition file " + internal.LogCallDefinitionFileName,
 	Args:  cobra.MaximumNArgs(1),
@@ -97,19 +148,185 @@ var corpusBuildCmd = &cobra.Command{
 		if len(args) > 0 {
 			repoPath = args[0]
 		}
-		corpus, err := internal.BuildCorpusFromRepo(repoPath)
+		jobs, err := cmd.Flags().GetInt("jobs")
+		if err != nil {
+			log.Fatal().Msgf("error getting jobs: %v", err)
+			return
+		}
+		failFast, err := cmd.Flags().GetBool("fail-fast")
+		if err != nil {
+			log.Fatal().Msgf("error getting fail-fast: %v", err)
+			return
+		}
+		keepGoing, err := cmd.Flags().GetBool("keep-going")
+		if err != nil {
+			log.Fatal().Msgf("error getting keep-going: %v", err)
+			return
+		}
+		// --keep-going=false is the same as --fail-fast
+		failFast = failFast || !keepGoing
+		rev, err := cmd.Flags().GetString("rev")
+		if err != nil {
+			log.Fatal().Msgf("error getting rev: %v", err)
+			return
+		}
+		version, err := cmd.Flags().GetString("version")
+		if err != nil {
+			log.Fatal().Msgf("error getting version: %v", err)
+			return
+		}
+		if version == "" {
+			version = rev
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		reportPath, err := cmd.Flags().GetString("report")
+		if err != nil {
+			log.Fatal().Msgf("error getting report: %v", err)
+			return
+		}
+		strict, err := cmd.Flags().GetBool("strict")
+		if err != nil {
+			log.Fatal().Msgf("error getting strict: %v", err)
+			return
+		}
+		maxDropRate, err := cmd.Flags().GetFloat64("max-drop-rate")
+		if err != nil {
+			log.Fatal().Msgf("error getting max-drop-rate: %v", err)
+			return
+		}
+		snippetContext, err := cmd.Flags().GetInt("snippet-context")
+		if err != nil {
+			log.Fatal().Msgf("error getting snippet-context: %v", err)
+			return
+		}
+		blame, err := cmd.Flags().GetBool("blame")
+		if err != nil {
+			log.Fatal().Msgf("error getting blame: %v", err)
+			return
+		}
+		corpusFiles, report, err := internal.BuildCorpusFromRepo(ctx, repoPath, internal.BuildOptions{
+			Jobs:           jobs,
+			FailFast:       failFast,
+			Revision:       rev,
+			Version:        version,
+			SnippetContext: snippetContext,
+			Blame:          blame,
+		})
 		if err != nil {
 			log.Fatal().Msgf("error building corpus: %v", err)
 			return
 		}
-		if err := corpus.Save(); err != nil {
-			log.Fatal().Msgf("error saving corpus: %v", err)
+		if err := report.WriteSummary(os.Stdout); err != nil {
+			log.Fatal().Msgf("error writing build report summary: %v", err)
+			return
+		}
+		if reportPath != "" {
+			if err := report.Save(reportPath); err != nil {
+				log.Fatal().Msgf("error saving build report: %v", err)
+				return
+			}
+			fmt.Printf("Build report written to %s\n", reportPath)
+		}
+		if strict && report.DropRate() > maxDropRate {
+			log.Fatal().Msgf("drop rate %.1f%% exceeds --max-drop-rate %.1f%%. Corpus is not saved", report.DropRate()*100, maxDropRate*100)
+			return
+		}
+		// All projects are saved together, so that a failure leaves none of them half-updated
+		if err := internal.SaveCorpusFiles(corpusFiles); err != nil {
+			log.Fatal().Msgf("error saving corpus: %v. No project was saved", err)
 			return
 		}
+		for _, corpus := range corpusFiles {
+			fmt.Printf("Corpus of project %s built with %d log calls\n", corpus.Project, len(corpus.Calls))
+		}
 		fmt.Println("Corpus built successfully")
 	},
 }
 
+var corpusLintCmd = &cobra.Command{
+	Use:   "lint {project} [version]",
+	Short: "Find log calls that can't be uniquely identified",
+	Long: `Find log calls whose log lines can't be reliably attributed to them, ranked from worst to best.
+A call is reported if its format string has too few literal characters (see min_match_chars and min_match_word_chars),
+if other call sites use the same format string, or if the patterns of other calls match its log lines at least as well.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		project := args[0]
+		version := ""
+		if len(args) > 1 {
+			version = args[1]
+		}
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			log.Fatal().Msgf("error getting limit: %v", err)
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal().Msgf("error getting json: %v", err)
+			return
+		}
+		corpus, err := internal.GlobalManifest.Load([]string{project})
+		if err != nil {
+			log.Fatal().Msgf("error reading corpus: %v", err)
+			return
+		}
+		if _, ok := corpus.Lookup(project, version); !ok {
+			log.Fatal().Msgf("No corpus file found for project: %s, version: %q\n", project, version)
+			return
+		}
+		config := internal.ViewConfig{
+			MinMatchChars:     viper.GetInt("min_match_chars"),
+			MinMatchWordChars: viper.GetInt("min_match_word_chars"),
+			MinMatchedRatio:   viper.GetFloat64("min_matched_ratio"),
+			ProjectFilter:     []string{project},
+			Versions:          map[string]string{project: version},
+		}
+		applyViewProfile(cmd, &config, corpus)
+		view, err := internal.NewViewer(config, corpus)
+		if err != nil {
+			log.Fatal().Msgf("error creating view: %v", err)
+			return
+		}
+		defer view.Close()
+		results, err := internal.LintProject(view, project)
+		if err != nil {
+			log.Fatal().Msgf("error linting project %s: %v", project, err)
+			return
+		}
+		if limit > 0 && len(results) > limit {
+			results = results[:limit]
+		}
+		if asJSON {
+			data, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				log.Fatal().Msgf("error marshalling lint results: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "RANK\tSCORE\tLOCATION\tFORMAT\tISSUES")
+		for i, result := range results {
+			issues := []string{}
+			for _, issue := range result.Issues {
+				switch issue {
+				case internal.LintIssueDuplicate:
+					issues = append(issues, fmt.Sprintf("%s(%d)", issue, len(result.Duplicates)))
+				case internal.LintIssueShadowed:
+					issues = append(issues, fmt.Sprintf("%s(%s:%d...)", issue, result.ShadowedBy[0].File, result.ShadowedBy[0].Line))
+				default:
+					issues = append(issues, string(issue))
+				}
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s:%d\t%q\t%s\n", i+1, result.Score, result.Call.File, result.Call.Line,
+				result.Call.FormatString, strings.Join(issues, ", "))
+		}
+		tw.Flush()
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(corpusCmd)
 	corpusCmd.AddCommand(corpusLsCmd)
@@ -117,6 +334,25 @@ func init() {
 	corpusCmd.AddCommand(corpusResetAllCmd)
 	corpusCmd.AddCommand(corpusNewConfigCmd)
 	corpusCmd.AddCommand(corpusBuildCmd)
+	corpusCmd.AddCommand(corpusLintCmd)
+	corpusCmd.AddCommand(corpusMigrateCmd)
+
+	corpusLintCmd.Flags().Int("limit", 50, "Maximum number of reported log calls. 0 reports all of them")
+	corpusLintCmd.Flags().Bool("json", false, "Output the results as JSON")
+
+	corpusBuildCmd.Flags().IntP("jobs", "j", 0, "Number of source files to process in parallel (default is the number of CPUs)")
+	corpusBuildCmd.Flags().Bool("fail-fast", false, "Abort the build on the first source file that fails to be processed")
+	corpusBuildCmd.Flags().Bool("keep-going", true, "Log and skip source files that fail to be processed. --keep-going=false is the same as --fail-fast")
+	corpusBuildCmd.MarkFlagsMutuallyExclusive("fail-fast", "keep-going")
+	corpusBuildCmd.Flags().String("rev", "", "Build from this git revision instead of the working directory. "+
+		"The repo path may then also be a bare repo or a git bundle")
+	corpusBuildCmd.Flags().String("report", "", "Write a JSON report of kept and dropped log call matches to this file")
+	corpusBuildCmd.Flags().Bool("strict", false, "Fail the build if the ratio of dropped log call matches exceeds --max-drop-rate")
+	corpusBuildCmd.Flags().Float64("max-drop-rate", 0.05, "Maximum ratio of dropped log call matches allowed by --strict")
+	corpusBuildCmd.Flags().String("version", "", "Version tag of the built corpus (default is the value of --rev). "+
+		"Building again with the same version replaces it, while other versions are kept")
+	corpusBuildCmd.Flags().Bool("blame", false, "Run git blame on every log call to store the author, date and subject of the last commit that changed it")
+	corpusBuildCmd.Flags().Int("snippet-context", 0, "Store this many source lines around each log call in the corpus, for 'view --context'")
 
 	// Here you will define your flags and configuration settings.
 
@@ -0,0 +1,258 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/htfy96/logalign/internal"
+	"github.com/phuslu/log"
+	"github.com/spf13/cobra"
+)
+
+// selectProject picks the corpus file of project from corpusFiles, or of the
+// only project if project is empty. Of several versions of the project, the
+// given version or else the latest build is picked.
+func selectProject(corpusFiles []*internal.CorpusFile, project string, version string, source string) (*internal.CorpusFile, error) {
+	projects := []string{}
+	for _, corpusFile := range corpusFiles {
+		if !slices.Contains(projects, corpusFile.Project) {
+			projects = append(projects, corpusFile.Project)
+		}
+	}
+	if project == "" && len(projects) == 1 {
+		project = projects[0]
+	}
+	corpus := internal.NewCorpus()
+	for _, corpusFile := range corpusFiles {
+		corpus.AddCorpusFile(corpusFile)
+	}
+	if pc, ok := corpus[project]; ok {
+		corpusFile, ok := corpus.Lookup(project, version)
+		if !ok {
+			return nil, fmt.Errorf("%s has no version %q of project %s, only %q", source, version, project, pc.Versions())
+		}
+		return &corpusFile, nil
+	}
+	if project == "" {
+		return nil, fmt.Errorf("%s has projects %s. Select one with --project", source, strings.Join(projects, ", "))
+	}
+	return nil, fmt.Errorf("%s has no project %s, only %s", source, project, strings.Join(projects, ", "))
+}
+
+// Prefix of a side of 'corpus diff' that is an exported corpus file or bundle
+const exportedSpecPrefix = "file:"
+
+// splitExportedSpec splits a side of 'corpus diff' that is an exported corpus
+// file or bundle into its path and version. Such a side is written as
+// file:{path}[@{version}], or as a path starting with ./, ../ or /, so that
+// it can't be mistaken for a project or revision of the same name.
+func splitExportedSpec(spec string) (string, string, bool) {
+	filePath, ok := strings.CutPrefix(spec, exportedSpecPrefix)
+	if !ok && !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") && !filepath.IsAbs(spec) {
+		return "", "", false
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		return filePath, "", true
+	}
+	if i := strings.LastIndex(filePath, "@"); i > 0 {
+		if _, err := os.Stat(filePath[:i]); err == nil {
+			return filePath[:i], filePath[i+1:], true
+		}
+	}
+	return filePath, "", ok
+}
+
+// corpusSide resolves a side of 'corpus diff' that is a project of the corpus,
+// as {project} or {project}@{version}, or a {version} of project.
+func corpusSide(spec string, project string) (string, string, bool) {
+	name, version, _ := strings.Cut(spec, "@")
+	if slices.Contains(internal.GlobalManifest.Projects(), name) {
+		return name, version, true
+	}
+	for _, entry := range internal.GlobalManifest.Entries {
+		if project != "" && entry.Project == project && entry.Version == spec {
+			return project, spec, true
+		}
+	}
+	return "", "", false
+}
+
+// loadDiffSide reads a side of 'corpus diff' that is an exported corpus file or
+// bundle, or a project of the corpus. The latest build is used unless a version
+// is given. ok is false if spec is neither, and names a git revision.
+func loadDiffSide(spec string, project string) (*internal.CorpusFile, bool, error) {
+	if filePath, version, ok := splitExportedSpec(spec); ok {
+		corpusFiles, err := internal.ReadExportedCorpus(filePath)
+		if err != nil {
+			return nil, true, err
+		}
+		corpusFile, err := selectProject(corpusFiles, project, version, filePath)
+		return corpusFile, true, err
+	}
+	name, version, ok := corpusSide(spec, project)
+	if !ok {
+		return nil, false, nil
+	}
+	corpus, err := internal.GlobalManifest.Load([]string{name})
+	if err != nil {
+		return nil, true, err
+	}
+	corpusFile, ok := corpus.Lookup(name, version)
+	if !ok {
+		return nil, true, fmt.Errorf("no corpus file found for project: %s, version: %q", name, version)
+	}
+	return &corpusFile, true, nil
+}
+
+// buildDiffSide builds the corpus of a project from a git revision of repoPath.
+func buildDiffSide(ctx context.Context, repoPath string, rev string, project string) (*internal.CorpusFile, error) {
+	corpusFiles, _, err := internal.BuildCorpusFromRepo(ctx, repoPath, internal.BuildOptions{
+		Revision: rev,
+		Version:  rev,
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := []*internal.CorpusFile{}
+	for i := range corpusFiles {
+		files = append(files, &corpusFiles[i])
+	}
+	return selectProject(files, project, "", fmt.Sprintf("revision %s of %s", rev, repoPath))
+}
+
+func formatDiffCall(call *internal.LogCall) string {
+	parts := []string{fmt.Sprintf("%q", call.FormatString)}
+	parts = append(parts, call.ArgumentExprs...)
+	level := call.NormalizedLevel()
+	if level == "" {
+		level = "-"
+	}
+	return fmt.Sprintf("[%s] %s(%s)", level, call.Method, strings.Join(parts, ", "))
+}
+
+// Names of the changes in the human-readable output
+var callChangeNames = map[internal.CallChange]string{
+	internal.CallChangeFormat:    "reworded",
+	internal.CallChangeArguments: "arguments changed",
+	internal.CallChangeLevel:     "level changed",
+	internal.CallChangeMethod:    "method changed",
+	internal.CallChangeFile:      "moved to another file",
+	internal.CallChangeFunction:  "moved to another function",
+}
+
+func printCorpusDiff(diff *internal.CorpusDiff) {
+	fmt.Printf("--- %s@%s\n", diff.OldProject, diff.OldVersion)
+	fmt.Printf("+++ %s@%s\n", diff.NewProject, diff.NewVersion)
+	for _, d := range diff.Diffs {
+		switch d.Kind {
+		case internal.CallAdded:
+			fmt.Printf("+ %s:%d %s\n", d.New.File, d.New.Line, formatDiffCall(d.New))
+		case internal.CallRemoved:
+			fmt.Printf("- %s:%d %s\n", d.Old.File, d.Old.Line, formatDiffCall(d.Old))
+		case internal.CallChanged:
+			changes := []string{}
+			for _, change := range d.Changes {
+				changes = append(changes, callChangeNames[change])
+			}
+			fmt.Printf("~ %s:%d -> %s:%d (%s)\n", d.Old.File, d.Old.Line, d.New.File, d.New.Line, strings.Join(changes, ", "))
+			fmt.Printf("    - %s\n", formatDiffCall(d.Old))
+			fmt.Printf("    + %s\n", formatDiffCall(d.New))
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d changed, %d unchanged\n",
+		diff.Count(internal.CallAdded), diff.Count(internal.CallRemoved), diff.Count(internal.CallChanged), diff.Unchanged)
+}
+
+var corpusDiffCmd = &cobra.Command{
+	Use:   "diff {old} {new}",
+	Short: "Show log calls added, removed or changed between two corpora",
+	Long: `Show the log calls that were added, removed, reworded or had their arguments changed from old to new.
+Each side is a project of the corpus as {project} or {project}@{version}, a {version} of the project given by
+--project or by the other side, an exported corpus file or bundle as file:{path} or file:{path}@{version}
+(or a path starting with ./, ../ or /), or otherwise a git revision of the repo given by --repo, which is built on the fly.
+Calls are matched by their ID, then by identical format strings, and finally by the similarity of their
+format strings, arguments and locations. Calls that only moved to other lines are not reported.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal().Msgf("error getting json: %v", err)
+			return
+		}
+		repoPath, err := cmd.Flags().GetString("repo")
+		if err != nil {
+			log.Fatal().Msgf("error getting repo: %v", err)
+			return
+		}
+		project, err := cmd.Flags().GetString("project")
+		if err != nil {
+			log.Fatal().Msgf("error getting project: %v", err)
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		sides := make([]*internal.CorpusFile, 2)
+		// A second pass resolves a {version} given before the side naming its project
+		for pass := 0; pass < 2; pass++ {
+			for i, spec := range args {
+				if sides[i] != nil {
+					continue
+				}
+				side, ok, err := loadDiffSide(spec, project)
+				if err != nil {
+					log.Fatal().Msgf("error reading %s: %v", spec, err)
+					return
+				} else if !ok {
+					continue
+				}
+				sides[i] = side
+				if project == "" {
+					project = sides[i].Project
+				}
+			}
+		}
+		// Revisions are built last, so that they default to the project of the other side
+		for i, spec := range args {
+			if sides[i] != nil {
+				continue
+			}
+			if sides[i], err = buildDiffSide(ctx, repoPath, spec, project); err != nil {
+				log.Fatal().Msgf("error building %s: %v", spec, err)
+				return
+			}
+			if project == "" {
+				project = sides[i].Project
+			}
+		}
+
+		diff := internal.DiffCorpusFiles(sides[0], sides[1])
+		if asJSON {
+			data, err := json.MarshalIndent(diff, "", "  ")
+			if err != nil {
+				log.Fatal().Msgf("error marshalling corpus diff: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printCorpusDiff(diff)
+	},
+}
+
+func init() {
+	corpusCmd.AddCommand(corpusDiffCmd)
+
+	corpusDiffCmd.Flags().Bool("json", false, "Output the diff as JSON")
+	corpusDiffCmd.Flags().String("repo", ".", "Repo to build git revisions from")
+	corpusDiffCmd.Flags().String("project", "", "Project to compare if a side has several, and whose versions a side may name. Defaults to the project of the other side")
+}
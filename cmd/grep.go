@@ -0,0 +1,188 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/htfy96/logalign/internal"
+	"github.com/phuslu/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// grepResult is a call found by 'corpus grep'.
+type grepResult struct {
+	Version string           `json:"version"`
+	Call    internal.LogCall `json:"call"`
+}
+
+func printGrepTable(results []internal.SampleLineMatch, withMatch bool) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "PROJECT\tVERSION\tID\tLOCATION\tLEVEL\tMETHOD\tFORMAT\tARGUMENTS"
+	if withMatch {
+		header = "MATCHED\tCONFIDENT\t" + header
+	}
+	fmt.Fprintln(tw, header)
+	for _, result := range results {
+		call := result.Call
+		if withMatch {
+			fmt.Fprintf(tw, "%d/%d\t%t\t", result.MatchedLiterals, result.MatchedTotal, result.Confident)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s:%d\t%s\t%s\t%q\t%s\n", call.Project, result.Version, call.ID, call.File, call.Line,
+			call.NormalizedLevel(), call.Method, call.FormatString, strings.Join(call.ArgumentExprs, ", "))
+	}
+	tw.Flush()
+}
+
+var corpusGrepCmd = &cobra.Command{
+	Use:     "grep [format regex]",
+	Aliases: []string{"find"},
+	Short:   "Search log calls of the corpus",
+	Long: `Search log calls by a regex on their format strings, their location, method, level, definition or argument expressions.
+With --sample-line, list the calls whose patterns match a logged text instead, best match first, as 'logalign view' would see them.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		query := internal.CallQuery{}
+		if len(args) > 0 {
+			query.Format = args[0]
+		}
+		var err error
+		if query.Location, err = cmd.Flags().GetString("location"); err != nil {
+			log.Fatal().Msgf("error getting location: %v", err)
+			return
+		}
+		if query.Method, err = cmd.Flags().GetString("method"); err != nil {
+			log.Fatal().Msgf("error getting method: %v", err)
+			return
+		}
+		if query.Levels, err = cmd.Flags().GetStringSlice("level"); err != nil {
+			log.Fatal().Msgf("error getting level: %v", err)
+			return
+		}
+		if query.DefinitionID, err = cmd.Flags().GetString("definition"); err != nil {
+			log.Fatal().Msgf("error getting definition: %v", err)
+			return
+		}
+		if query.Argument, err = cmd.Flags().GetString("arg"); err != nil {
+			log.Fatal().Msgf("error getting arg: %v", err)
+			return
+		}
+		sampleLine, err := cmd.Flags().GetString("sample-line")
+		if err != nil {
+			log.Fatal().Msgf("error getting sample-line: %v", err)
+			return
+		}
+		projects, err := cmd.Flags().GetStringSlice("project")
+		if err != nil {
+			log.Fatal().Msgf("error getting project: %v", err)
+			return
+		}
+		versionFlags, err := cmd.Flags().GetStringArray("version")
+		if err != nil {
+			log.Fatal().Msgf("error getting version: %v", err)
+			return
+		}
+		versions := parseVersionFlags(versionFlags)
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			log.Fatal().Msgf("error getting limit: %v", err)
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal().Msgf("error getting json: %v", err)
+			return
+		}
+
+		corpus, err := internal.GlobalManifest.Load(projects)
+		if err != nil {
+			log.Fatal().Msgf("error reading corpus: %v", err)
+			return
+		}
+		config := internal.ViewConfig{
+			MinMatchChars:     viper.GetInt("min_match_chars"),
+			MinMatchWordChars: viper.GetInt("min_match_word_chars"),
+			MinMatchedRatio:   viper.GetFloat64("min_matched_ratio"),
+			ProjectFilter:     projects,
+			Versions:          versions,
+		}
+		results := []internal.SampleLineMatch{}
+		if sampleLine != "" {
+			applyViewProfile(cmd, &config, corpus)
+			view, err := internal.NewViewer(config, corpus)
+			if err != nil {
+				log.Fatal().Msgf("error creating view: %v", err)
+				return
+			}
+			defer view.Close()
+			if results, err = view.MatchSampleLine(sampleLine, query); err != nil {
+				log.Fatal().Msgf("error matching sample line: %v", err)
+				return
+			}
+		} else {
+			for _, project := range slices.Sorted(maps.Keys(corpus)) {
+				corpusFile, fellBack, err := corpus.SelectVersion(project, versions)
+				if err != nil {
+					log.Fatal().Msgf("error selecting version: %v", err)
+					return
+				}
+				if fellBack {
+					log.Warn().Msgf("Project %s has no version %q. Searching its latest build, version %q", project, versions[""], corpusFile.Version)
+				}
+				calls, err := internal.SearchCalls(corpusFile.Calls, query)
+				if err != nil {
+					log.Fatal().Msgf("error searching calls: %v", err)
+					return
+				}
+				for _, call := range calls {
+					results = append(results, internal.SampleLineMatch{Call: call, Version: corpusFile.Version})
+				}
+			}
+		}
+		if limit > 0 && len(results) > limit {
+			results = results[:limit]
+		}
+
+		if asJSON {
+			var data []byte
+			if sampleLine != "" {
+				data, err = json.MarshalIndent(results, "", "  ")
+			} else {
+				calls := []grepResult{}
+				for _, result := range results {
+					calls = append(calls, grepResult{Version: result.Version, Call: result.Call})
+				}
+				data, err = json.MarshalIndent(calls, "", "  ")
+			}
+			if err != nil {
+				log.Fatal().Msgf("error marshalling search results: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printGrepTable(results, sampleLine != "")
+	},
+}
+
+func init() {
+	corpusCmd.AddCommand(corpusGrepCmd)
+
+	corpusGrepCmd.Flags().String("location", "", "Only list calls spanning {file}:{line}, or in files with this path prefix")
+	corpusGrepCmd.Flags().String("method", "", "Only list calls whose method matches this regex")
+	corpusGrepCmd.Flags().StringSlice("level", []string{}, fmt.Sprintf("Only list calls with one of these levels %q", internal.AllLogLevels))
+	corpusGrepCmd.Flags().String("definition", "", "Only list calls found by this definition ID")
+	corpusGrepCmd.Flags().String("arg", "", "Only list calls with an argument expression containing this substring")
+	corpusGrepCmd.Flags().String("sample-line", "", "List the calls whose patterns match this logged text, without the prefix of the log line")
+	corpusGrepCmd.Flags().StringSlice("project", []string{}, "Projects to search. All projects are searched if empty")
+	corpusGrepCmd.Flags().StringArray("version", []string{}, "Version to search, as {version} for all projects or {project}={version}. Defaults to the latest build")
+	corpusGrepCmd.Flags().Int("limit", 100, "Maximum number of listed calls. 0 lists all of them")
+	corpusGrepCmd.Flags().Bool("json", false, "Output the results as JSON")
+}
@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/htfy96/logalign/internal"
+	"github.com/phuslu/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var corpusRmCmd = &cobra.Command{
+	Use:   "rm {project} [version]",
+	Short: "Remove a project from the corpus",
+	Long:  "Remove all versions of a project from the corpus, or only the given version",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		project := args[0]
+		version := ""
+		if len(args) > 1 {
+			version = args[1]
+		}
+		removed, err := internal.RemoveProject(project, version, len(args) == 1)
+		if err != nil {
+			log.Fatal().Msgf("error removing project %s: %v", project, err)
+			return
+		}
+		for _, entry := range removed {
+			fmt.Printf("Removed project: %s. Version: %q\n", entry.Project, entry.Version)
+		}
+	},
+}
+
+var corpusRenameCmd = &cobra.Command{
+	Use:   "rename {old project} {new project}",
+	Short: "Rename a project in the corpus",
+	Long:  "Rename all versions of a project in the corpus",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		renamed, err := internal.RenameProject(args[0], args[1])
+		if err != nil {
+			log.Fatal().Msgf("error renaming project %s: %v", args[0], err)
+			return
+		}
+		fmt.Printf("Renamed %d versions of project %s to %s\n", renamed, args[0], args[1])
+	},
+}
+
+var corpusPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove stale builds from the corpus",
+	Long: `Remove builds of each project beyond the --keep most recent ones, or built before --older-than.
+The latest build of each project is always kept. Manifest entries whose corpus file is missing are removed as well.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keep, err := cmd.Flags().GetInt("keep")
+		if err != nil {
+			log.Fatal().Msgf("error getting keep: %v", err)
+			return
+		}
+		olderThan, err := cmd.Flags().GetDuration("older-than")
+		if err != nil {
+			log.Fatal().Msgf("error getting older-than: %v", err)
+			return
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal().Msgf("error getting dry-run: %v", err)
+			return
+		}
+		removed, err := internal.PruneCorpus(internal.PruneOptions{Keep: keep, OlderThan: olderThan, DryRun: dryRun})
+		if err != nil {
+			log.Fatal().Msgf("error pruning corpus: %v", err)
+			return
+		}
+		action := "Removed"
+		if dryRun {
+			action = "Would remove"
+		}
+		for _, entry := range removed {
+			fmt.Printf("%s project: %s. Version: %q. Built at: %s\n", action, entry.Project, entry.Version, entry.BuiltAt.Format(time.DateTime))
+		}
+		fmt.Printf("%s %d corpus files\n", action, len(removed))
+	},
+}
+
+var corpusExportCmd = &cobra.Command{
+	Use:   "export {bundle path} [projects...]",
+	Short: "Export the corpus to a portable bundle",
+	Long: `Write all versions of the given projects (or of all projects) with their log call definitions to a single bundle,
+which can be imported by 'logalign corpus import' on another machine without the source tree.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bundlePath, projects := args[0], args[1:]
+		withHSDB, err := cmd.Flags().GetBool("with-hsdb")
+		if err != nil {
+			log.Fatal().Msgf("error getting with-hsdb: %v", err)
+			return
+		}
+		opts := internal.ExportOptions{Projects: projects}
+		if withHSDB {
+			corpus, err := internal.GlobalManifest.Load(projects)
+			if err != nil {
+				log.Fatal().Msgf("error reading corpus: %v", err)
+				return
+			}
+			// The database is built with the default view settings, so that it is found in the cache by a plain 'view'
+			view, err := internal.NewViewer(internal.ViewConfig{
+				MinMatchChars:     viper.GetInt("min_match_chars"),
+				MinMatchWordChars: viper.GetInt("min_match_word_chars"),
+				MinMatchedRatio:   viper.GetFloat64("min_matched_ratio"),
+			}, corpus)
+			if err != nil {
+				log.Fatal().Msgf("error creating view: %v", err)
+				return
+			}
+			opts.HSDBPath = view.HSDBCachePath
+			view.Close()
+		}
+		f, err := os.Create(bundlePath)
+		if err != nil {
+			log.Fatal().Msgf("error creating bundle: %v", err)
+			return
+		}
+		defer f.Close()
+		index, err := internal.ExportBundle(f, opts)
+		if err != nil {
+			log.Fatal().Msgf("error exporting corpus: %v", err)
+			return
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal().Msgf("error writing bundle: %v", err)
+			return
+		}
+		for _, entry := range index.Entries {
+			fmt.Printf("Exported project: %s. Version: %q. Calls: %d\n", entry.Project, entry.Version, entry.Calls)
+		}
+		if index.HSDB != "" {
+			fmt.Printf("Exported hyperscan database for %s\n", index.HSDBPlatform)
+		}
+		fmt.Printf("Bundle written to %s\n", bundlePath)
+	},
+}
+
+var corpusImportCmd = &cobra.Command{
+	Use:   "import {bundle path}",
+	Short: "Import a bundle written by 'corpus export'",
+	Long:  "Add the corpus files of a bundle written by 'logalign corpus export' to the corpus. Existing versions of a project are kept unless --replace is set",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		replace, err := cmd.Flags().GetBool("replace")
+		if err != nil {
+			log.Fatal().Msgf("error getting replace: %v", err)
+			return
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal().Msgf("error opening bundle: %v", err)
+			return
+		}
+		defer f.Close()
+		result, err := internal.ImportBundle(f, replace)
+		if err != nil {
+			log.Fatal().Msgf("error importing bundle: %v", err)
+			return
+		}
+		for _, entry := range result.Imported {
+			fmt.Printf("Imported project: %s. Version: %q. Calls: %d\n", entry.Project, entry.Version, entry.Calls)
+		}
+		for _, entry := range result.Skipped {
+			fmt.Printf("Skipped existing project: %s. Version: %q. Use --replace to replace it\n", entry.Project, entry.Version)
+		}
+		if result.HSDBInstalled {
+			fmt.Println("Installed prebuilt hyperscan database")
+		}
+	},
+}
+
+func init() {
+	corpusCmd.AddCommand(corpusRmCmd)
+	corpusCmd.AddCommand(corpusRenameCmd)
+	corpusCmd.AddCommand(corpusPruneCmd)
+	corpusCmd.AddCommand(corpusExportCmd)
+	corpusCmd.AddCommand(corpusImportCmd)
+
+	corpusPruneCmd.Flags().Int("keep", 3, "Number of most recent builds to keep per project. 0 keeps all of them")
+	corpusPruneCmd.Flags().Duration("older-than", 0, "Also remove builds older than this, e.g. 720h")
+	corpusPruneCmd.Flags().Bool("dry-run", false, "Only print the builds that would be removed")
+	corpusExportCmd.Flags().Bool("with-hsdb", false, "Include the prebuilt hyperscan database of the latest versions for the current platform. "+
+		"It is only used by a 'view' of exactly the exported projects at their latest versions, with no other projects in the corpus or a matching --projects")
+	corpusImportCmd.Flags().Bool("replace", false, "Replace versions of projects that already exist")
+}
@@ -44,6 +44,7 @@ func initFromGlobalConfig() {
 		},
 	}
 	internal.CorpusDir = viper.GetString("corpus_dir")
+	internal.CorpusPath = viper.GetStringSlice("corpus_path")
 	if _, err := os.Stat(internal.CorpusDir); os.IsNotExist(err) {
 		log.Info().Msgf("Creating corpus directory at %s", internal.CorpusDir)
 	}
@@ -53,9 +54,12 @@ func initFromGlobalConfig() {
 		log.Fatal().Msgf("error creating data directory: %v", err)
 	}
 
-	internal.GlobalCorpus, err = internal.ReadCorpus()
+	internal.GlobalManifest, err = internal.ReadManifest()
 	if err != nil {
-		log.Fatal().Msgf("error reading corpus: %v", err)
+		log.Fatal().Msgf("error reading corpus manifest: %v", err)
+	}
+	if legacyFiles, err := internal.LegacyCorpusFiles(); err == nil && len(legacyFiles) > 0 {
+		log.Warn().Msgf("Found %d corpus files in the old JSON format in %s. Run 'logalign corpus migrate' to use them", len(legacyFiles), internal.CorpusDir)
 	}
 
 	if cpuProfile, err := rootCmd.PersistentFlags().GetString("cpuprofile"); err != nil {
@@ -79,7 +83,7 @@ var rootCmd = &cobra.Command{
 'logalign corpus' builds and maintains a corpus of log calls from different projects.
 'logalign view' outputs log lines based on previously built corpus.
 
-Some flags (e.g., corpus_dir, cache_dir, loglevel, source_column_width, min_matched_ratio, skip_print_argument_expr) can be set via $XDG_CONFIG_HOME/.logalign.yaml or ~/.logalign.yaml.
+Some flags (e.g., corpus_dir, corpus_path, cache_dir, loglevel, source_column_width, min_matched_ratio, skip_print_argument_expr) can be set via $XDG_CONFIG_HOME/.logalign.yaml or ~/.logalign.yaml.
 
 Set 'CLICOLOR_FORCE' or 'NO_COLOR' to force color output regardless of the terminal.
 `,
@@ -109,6 +113,8 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.logalign.yaml)")
 	rootCmd.PersistentFlags().String("corpus_dir", "", "corpus directory (default is $XDG_STATE_HOME/logalign)")
 	viper.BindPFlag("corpus_dir", rootCmd.PersistentFlags().Lookup("corpus_dir"))
+	rootCmd.PersistentFlags().StringSlice("corpus_path", []string{}, "additional read-only corpus directories, lowest precedence first. corpus_dir takes precedence over all of them")
+	viper.BindPFlag("corpus_path", rootCmd.PersistentFlags().Lookup("corpus_path"))
 	rootCmd.PersistentFlags().String("cache_dir", "", "cache directory (default is $XDG_CACHE_HOME/logalign)")
 	viper.BindPFlag("cache_dir", rootCmd.PersistentFlags().Lookup("cache_dir"))
 	rootCmd.PersistentFlags().String("loglevel", "info", "log level (trace, debug, info, warn, error, fatal, panic)")
@@ -0,0 +1,207 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/htfy96/logalign/internal"
+	"github.com/phuslu/log"
+	"github.com/spf13/cobra"
+)
+
+var corpusTryQueryCmd = &cobra.Command{
+	Use:   "try-query {--definition id | --query query --language lang} files...",
+	Short: "Run a log call definition query against source files",
+	Long: `Run the tree-sitter query of a log call definition against source files, and print every match with its
+@method, @format_string and @argument_expr captures, the parsed format, whether it would be kept in the corpus
+and the generated regex.
+
+The definition is either looked up by --definition in ` + internal.LogCallDefinitionFileName + ` of --repo, or given inline by --query.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		repoPath, err := cmd.Flags().GetString("repo")
+		if err != nil {
+			log.Fatal().Msgf("error getting repo: %v", err)
+			return
+		}
+		definitionID, err := cmd.Flags().GetString("definition")
+		if err != nil {
+			log.Fatal().Msgf("error getting definition: %v", err)
+			return
+		}
+		watch, err := cmd.Flags().GetBool("watch")
+		if err != nil {
+			log.Fatal().Msgf("error getting watch: %v", err)
+			return
+		}
+		loadDefinition := func() (*internal.LogCallDefinition, error) {
+			if definitionID != "" {
+				return internal.LoadLogCallDefinition(repoPath, definitionID)
+			}
+			return inlineDefinition(cmd)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		run := func() {
+			def, err := loadDefinition()
+			if err != nil {
+				fmt.Printf("Invalid log call definition: %v\n", err)
+				return
+			}
+			defer def.Close()
+			printTryQueryResults(internal.TryQuery(ctx, def, args))
+		}
+		run()
+		if !watch {
+			return
+		}
+
+		watchedFiles := slices.Clone(args)
+		if definitionID != "" {
+			// The definition may be in a nested definition file
+			configFiles, err := internal.WorkspaceConfigFiles(repoPath)
+			if err != nil {
+				configFiles = []string{filepath.Join(repoPath, internal.LogCallDefinitionFileName)}
+			}
+			watchedFiles = append(watchedFiles, configFiles...)
+		}
+		if err := watchFiles(ctx, watchedFiles, func() {
+			fmt.Printf("\n===== %s =====\n", time.Now().Format(time.TimeOnly))
+			run()
+		}); err != nil {
+			log.Fatal().Msgf("error watching files: %v", err)
+		}
+	},
+}
+
+func inlineDefinition(cmd *cobra.Command) (*internal.LogCallDefinition, error) {
+	query, err := cmd.Flags().GetString("query")
+	if err != nil {
+		return nil, err
+	}
+	if query == "" {
+		return nil, fmt.Errorf("either --definition or --query is required")
+	}
+	language, err := cmd.Flags().GetString("language")
+	if err != nil {
+		return nil, err
+	}
+	syntax, err := cmd.Flags().GetString("syntax")
+	if err != nil {
+		return nil, err
+	}
+	stripTailingNewLine, err := cmd.Flags().GetBool("strip_tailing_newline")
+	if err != nil {
+		return nil, err
+	}
+	def := &internal.LogCallDefinition{
+		ID:                  "inline",
+		Query:               query,
+		Language:            language,
+		Syntax:              internal.LogCallSyntax(syntax),
+		StripTailingNewLine: stripTailingNewLine,
+	}
+	if err := def.Compile(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+func printTryQueryResults(results []internal.TryQueryResult) {
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%s: %v\n", result.File, result.Err)
+			continue
+		}
+		if result.SyntaxError != nil {
+			fmt.Printf("%s:%d: warning: %s. Log calls around it may not be matched\n", result.File, result.SyntaxError.Line, result.SyntaxError.Detail)
+		}
+		if len(result.Matches) == 0 {
+			fmt.Printf("%s: no matches\n", result.File)
+		}
+		for _, match := range result.Matches {
+			status := "kept"
+			if match.DropReason != "" {
+				status = fmt.Sprintf("dropped (%s: %s)", match.DropReason, match.DropDetail)
+			}
+			fmt.Printf("%s:%d: %s\n", match.Call.File, match.Call.Line, status)
+			for _, capture := range match.Captures {
+				fmt.Printf("    @%-16s line %-5d %s\n", capture.Name, capture.Line, strings.ReplaceAll(capture.Content, "\n", "\\n"))
+			}
+			fmt.Printf("    format string:    %q\n", match.Call.FormatString)
+			if match.Call.Function != "" {
+				fmt.Printf("    function:         %s\n", match.Call.Function)
+			}
+			if match.Parsed.Regex != "" {
+				fmt.Printf("    parsed arguments: %d (%d argument expressions captured)\n", match.Parsed.ArgCnt, len(match.Call.ArgumentExprs))
+				fmt.Printf("    regex:            %s\n", match.Parsed.Regex)
+				fmt.Printf("    hyperscan regex:  %s\n", match.Parsed.HyperScanRegex)
+			}
+		}
+	}
+}
+
+// watchFiles calls onChange whenever one of the files is written, created or
+// renamed, until ctx is cancelled. Directories are watched instead of the
+// files themselves, so that editors replacing a file are noticed as well.
+func watchFiles(ctx context.Context, files []string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	watched := make(map[string]bool)
+	for _, file := range files {
+		absPath, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		watched[absPath] = true
+		if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+			return fmt.Errorf("error watching %s: %w", filepath.Dir(absPath), err)
+		}
+	}
+	// Editors tend to produce several events per save
+	const debounce = 100 * time.Millisecond
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.Events:
+			// Some editors save with a single Write|Chmod event
+			if watched[event.Name] && (event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename)) {
+				timer.Reset(debounce)
+			}
+		case err := <-watcher.Errors:
+			log.Warn().Msgf("error watching files: %v", err)
+		case <-timer.C:
+			onChange()
+		}
+	}
+}
+
+func init() {
+	corpusCmd.AddCommand(corpusTryQueryCmd)
+	corpusTryQueryCmd.Flags().String("repo", ".", "Repo to look up --definition in")
+	corpusTryQueryCmd.Flags().String("definition", "", "ID of the log call definition to run")
+	corpusTryQueryCmd.Flags().String("query", "", "Inline tree-sitter query to run instead of --definition")
+	corpusTryQueryCmd.Flags().String("language", "c", "Language of the inline query")
+	corpusTryQueryCmd.Flags().String("syntax", string(internal.LogCallSyntaxPrintflike), "Format string syntax of the inline query")
+	corpusTryQueryCmd.Flags().Bool("strip_tailing_newline", false, "Remove redundant '\\n' at the end of @format_string of the inline query")
+	corpusTryQueryCmd.Flags().Bool("watch", false, "Run again whenever the files or the definition files change")
+	corpusTryQueryCmd.MarkFlagsMutuallyExclusive("definition", "query")
+}
@@ -5,8 +5,11 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/htfy96/logalign/internal"
 	"github.com/phuslu/log"
@@ -15,6 +18,30 @@ import (
 	"go.uber.org/atomic"
 )
 
+// applyViewProfile applies the [view] profile of the selected projects to the
+// settings that aren't set by a flag, an environment variable or ~/.logalign.yaml.
+func applyViewProfile(cmd *cobra.Command, config *internal.ViewConfig, corpus internal.Corpus) {
+	profile := corpus.ViewProfile(config.ProjectFilter, config.Versions)
+	config.ApplyProfile(profile, func(setting string) bool {
+		_, inEnv := os.LookupEnv("LOGALIGN_" + strings.ToUpper(setting))
+		return cmd.Flags().Changed(setting) || viper.InConfig(setting) || inEnv
+	})
+}
+
+// parseVersionFlags reads --version flags, each either {version} for all
+// projects or {project}={version}, into ViewConfig.Versions.
+func parseVersionFlags(versionFlags []string) map[string]string {
+	versions := make(map[string]string)
+	for _, v := range versionFlags {
+		project, version, ok := strings.Cut(v, "=")
+		if !ok {
+			project, version = "", v
+		}
+		versions[project] = version
+	}
+	return versions
+}
+
 // viewCmd represents the view command
 var viewCmd = &cobra.Command{
 	Use:   "view",
@@ -22,11 +49,6 @@ var viewCmd = &cobra.Command{
 	Long:  `Output log lines based on previously built corpus`,
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		corpus, err := internal.ReadCorpus()
-		if err != nil {
-			log.Fatal().Msgf("error reading corpus: %v", err)
-			return
-		}
 		startPos, err := cmd.PersistentFlags().GetInt("start_pos")
 		if err != nil {
 			log.Fatal().Msgf("error getting start_pos: %v", err)
@@ -42,16 +64,98 @@ var viewCmd = &cobra.Command{
 			log.Fatal().Msgf("error getting projects: %v", err)
 			return
 		}
+		corpus, err := internal.GlobalManifest.Load(projects)
+		if err != nil {
+			log.Fatal().Msgf("error reading corpus: %v", err)
+			return
+		}
+		versionFlags, err := cmd.PersistentFlags().GetStringArray("version")
+		if err != nil {
+			log.Fatal().Msgf("error getting version: %v", err)
+			return
+		}
+		versions := parseVersionFlags(versionFlags)
+		detectVersion, err := cmd.PersistentFlags().GetBool("detect_version")
+		if err != nil {
+			log.Fatal().Msgf("error getting detect_version: %v", err)
+			return
+		}
+		levels, err := cmd.PersistentFlags().GetStringSlice("level")
+		if err != nil {
+			log.Fatal().Msgf("error getting level: %v", err)
+			return
+		}
+		loggerFilter, err := cmd.PersistentFlags().GetString("logger")
+		if err != nil {
+			log.Fatal().Msgf("error getting logger: %v", err)
+			return
+		}
+		functionFilter, err := cmd.PersistentFlags().GetString("function")
+		if err != nil {
+			log.Fatal().Msgf("error getting function: %v", err)
+			return
+		}
+		contextLines, err := cmd.PersistentFlags().GetInt("context")
+		if err != nil {
+			log.Fatal().Msgf("error getting context: %v", err)
+			return
+		}
+		sourceRoots := make(map[string]string)
+		for _, v := range viper.GetStringSlice("source_root") {
+			// Either {path} for all projects, or {project}={path}
+			project, sourceRoot, ok := strings.Cut(v, "=")
+			if !ok {
+				project, sourceRoot = "", v
+			}
+			sourceRoots[project] = sourceRoot
+		}
+		fieldFlags, err := cmd.PersistentFlags().GetStringArray("field")
+		if err != nil {
+			log.Fatal().Msgf("error getting field: %v", err)
+			return
+		}
+		fieldFilters := make(map[string]string)
+		for _, v := range fieldFlags {
+			name, filter, ok := strings.Cut(v, "=")
+			if !ok {
+				log.Fatal().Msgf("invalid field filter %q, expected {field}={regex}", v)
+				return
+			}
+			fieldFilters[name] = filter
+		}
+		linePrefix := viper.GetString("line_prefix")
+		if linePrefix == internal.LinePrefixAuto && !cmd.PersistentFlags().Changed("line_prefix") && (startPos > 1 || startCharPos != "") {
+			// Counting characters is asked for explicitly
+			linePrefix = internal.LinePrefixNone
+		}
 		config := internal.ViewConfig{
 			MinMatchChars:         viper.GetInt("min_match_chars"),
 			MinMatchWordChars:     viper.GetInt("min_match_word_chars"),
 			MinMatchedRatio:       viper.GetFloat64("min_matched_ratio"),
+			LinePrefix:            linePrefix,
+			EnvelopeRegex:         viper.GetString("envelope_regex"),
+			FieldFilters:          fieldFilters,
+			ShowFields:            viper.GetStringSlice("show_fields"),
+			SourceLocation:        viper.GetString("source_location"),
 			StartPos:              startPos,
 			StartCharPos:          startCharPos,
 			SourceColumnWidth:     viper.GetInt("source_column_width"),
 			SkipPrintArgumentExpr: viper.GetBool("skip_print_argument_expr"),
 			ProjectFilter:         projects,
+			Versions:              versions,
+			DetectVersion:         detectVersion,
+			ShowCallInfo:          viper.GetBool("show_call_info"),
+			ShowBlame:             viper.GetBool("show_blame"),
+			MaxCorpusAge:          viper.GetDuration("max_corpus_age"),
+			LevelFilter:           levels,
+			LoggerFilter:          loggerFilter,
+			FunctionFilter:        functionFilter,
+			Context:               contextLines,
+			SourceRoots:           sourceRoots,
+			Editor:                viper.GetString("editor"),
+			Links:                 viper.GetStringSlice("links"),
 		}
+		applyViewProfile(cmd, &config, corpus)
 		if err := config.Validate(); err != nil {
 			log.Fatal().Msgf("error validating config: %v", err)
 			return
@@ -65,10 +169,14 @@ var viewCmd = &cobra.Command{
 		defer view.Close()
 
 		type InputLine struct {
+			Line     int
+			Content  *string
+			Versions internal.VersionSelection
+		}
+		type OutputLine struct {
 			Line    int
 			Content *string
 		}
-		type OutputLine InputLine
 
 		currLine := atomic.NewInt64(0)
 		inputQueue := internal.NewSafeQueue[InputLine]()
@@ -88,8 +196,11 @@ var viewCmd = &cobra.Command{
 				}
 				for {
 					line := inputQueue.WaitToPop()
-					processed, err := view.ProcessLine(*line.Content, scratch)
-					if err != nil {
+					processed, err := view.ProcessLine(*line.Content, scratch, line.Versions)
+					if errors.Is(err, internal.ErrFilteredOut) {
+						completionChan <- OutputLine{line.Line, nil}
+						continue
+					} else if err != nil {
 						errStr := fmt.Sprintf("Error processing line %d: %v", line.Line, err)
 						completionChan <- OutputLine{line.Line, &errStr}
 						continue
@@ -108,20 +219,59 @@ var viewCmd = &cobra.Command{
 					os.Exit(1)
 				}
 			}
-			scanner := bufio.NewScanner(reader)
-			for scanner.Scan() {
-				line := scanner.Text()
+			lines := make(chan string, 64)
+			go func() {
+				scanner := bufio.NewScanner(reader)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+				close(lines)
+			}()
+			versions := view.DefaultVersions
+			push := func(line string) {
 				oldCurrLine := currLine.Add(1) - 1
+				// Version markers apply to the following lines, so they must be detected in order
+				versions = view.DetectVersion(line, versions)
 
 				inputQueue.Push(InputLine{
-					Content: &line,
-					Line:    int(oldCurrLine),
+					Content:  &line,
+					Line:     int(oldCurrLine),
+					Versions: versions,
 				})
 			}
+			if config.LinePrefix == internal.LinePrefixAuto {
+				// The prefix is detected from the first lines before any line is processed. A log
+				// that is still being written is only waited for shortly after its first line
+				sample := []string{}
+				var timeout <-chan time.Time
+			sampling:
+				for len(sample) < internal.LinePrefixSampleLines {
+					select {
+					case line, ok := <-lines:
+						if !ok {
+							break sampling
+						}
+						if timeout == nil {
+							timeout = time.After(internal.LinePrefixSampleTimeout)
+						}
+						sample = append(sample, line)
+					case <-timeout:
+						break sampling
+					}
+				}
+				view.DetectLinePrefix(sample)
+				for _, line := range sample {
+					push(line)
+				}
+			}
+			for line := range lines {
+				push(line)
+			}
 			terminationChan <- 1
 		}()
 
 		terminated := false
+		// A nil content is a line that is filtered out
 		outputMap := make(map[int]*string)
 		nextToWrite := 0
 		for {
@@ -131,7 +281,9 @@ var viewCmd = &cobra.Command{
 				outputLine++
 				for {
 					if s, ok := outputMap[nextToWrite]; ok {
-						fmt.Printf("%s\n", *s)
+						if s != nil {
+							fmt.Printf("%s\n", *s)
+						}
 						nextToWrite++
 					} else {
 						break
@@ -158,6 +310,20 @@ func init() {
 	viper.BindPFlag("min_match_chars", viewCmd.PersistentFlags().Lookup("min_match_chars"))
 	viewCmd.PersistentFlags().Int("min_match_word_chars", 3, "Minimum number of word characters in a log line to match in a log line to qualify as a match")
 	viper.BindPFlag("min_match_word_chars", viewCmd.PersistentFlags().Lookup("min_match_word_chars"))
+	viper.SetDefault("line_prefix", internal.LinePrefixNone)
+	viewCmd.PersistentFlags().String("line_prefix", internal.LinePrefixNone, fmt.Sprintf("Prefix of log lines skipped by matching (%s). "+
+		"'auto' detects it from the first lines, and 'none' uses start_pos or start_char_pos", strings.Join(internal.LinePrefixNames(), ", ")))
+	viper.BindPFlag("line_prefix", viewCmd.PersistentFlags().Lookup("line_prefix"))
+	viewCmd.PersistentFlags().String("envelope_regex", "", "Regex splitting log lines into the logged message, captured by the named group 'msg', "+
+		"and fields captured by other named groups like time, level or suffix. Only the message is matched. "+
+		"Defaults to the envelope_regex of the [view] section of the projects")
+	viper.BindPFlag("envelope_regex", viewCmd.PersistentFlags().Lookup("envelope_regex"))
+	viewCmd.PersistentFlags().StringArray("field", []string{}, "Only output lines whose envelope field matches a regex, as {field}={regex}")
+	viewCmd.PersistentFlags().StringSlice("show_fields", []string{}, "Output these envelope fields in place of the text around the logged message")
+	viper.BindPFlag("show_fields", viewCmd.PersistentFlags().Lookup("show_fields"))
+	viewCmd.PersistentFlags().String("source_location", "", fmt.Sprintf("Source location printed in log lines, as a preset (%s) or a regex with the named groups 'file' and 'line'. "+
+		"Matches at that location are preferred, and matches elsewhere are flagged as stale", strings.Join(internal.SourceLocationNames(), ", ")))
+	viper.BindPFlag("source_location", viewCmd.PersistentFlags().Lookup("source_location"))
 	viewCmd.PersistentFlags().Int("start_pos", 1, "Start position for matching in log lines. (1-indexed)")
 	viewCmd.PersistentFlags().String("start_char_pos", "", "Only start to match log lines after n-th appearance of a specific character. "+
 		"If not provided, start_pos will be used. Example usage: --start_char_pos ' 1' will match only log lines after the first space.")
@@ -168,4 +334,30 @@ func init() {
 	viewCmd.PersistentFlags().Bool("skip_print_argument_expr", false, "Skip printing the matched argument expr in the output")
 	viper.BindPFlag("skip_print_argument_expr", viewCmd.PersistentFlags().Lookup("skip_print_argument_expr"))
 	viewCmd.PersistentFlags().StringArray("projects", []string{}, "Filter logs based on project names. If not provided, all logs will be displayed")
+	viewCmd.PersistentFlags().StringArray("version", []string{}, "Corpus version to match log lines against, either as {version} for all projects or {project}={version}. "+
+		"If not provided, or if a project lacks the {version} given for all projects, the latest built version of each project is used")
+	viper.SetDefault("show_call_info", false)
+	viewCmd.PersistentFlags().Bool("show_call_info", false, "Append the ID, level, logger and enclosing function of the matched log call to each line")
+	viper.BindPFlag("show_call_info", viewCmd.PersistentFlags().Lookup("show_call_info"))
+	viper.SetDefault("max_corpus_age", 0)
+	viewCmd.PersistentFlags().Duration("max_corpus_age", 0, "Warn if the corpus of a project was built longer ago than this, e.g. 720h. Disabled if 0")
+	viper.BindPFlag("max_corpus_age", viewCmd.PersistentFlags().Lookup("max_corpus_age"))
+	viper.SetDefault("show_blame", false)
+	viewCmd.PersistentFlags().Bool("show_blame", false, "Append the last commit that changed the matched log call to each line. "+
+		"Needs a corpus built with 'corpus build --blame'")
+	viper.BindPFlag("show_blame", viewCmd.PersistentFlags().Lookup("show_blame"))
+	viewCmd.PersistentFlags().StringSlice("level", []string{}, fmt.Sprintf("Only output lines matched to log calls of these levels (%s)", strings.Join(internal.AllLogLevels, ", ")))
+	viewCmd.PersistentFlags().String("logger", "", "Only output lines matched to log calls whose logger matches this regex")
+	viewCmd.PersistentFlags().String("function", "", "Only output lines matched to log calls whose enclosing function matches this regex")
+	viewCmd.PersistentFlags().Int("context", 0, "Print this many source lines around the matched log call under each line. "+
+		"Needs a corpus built with 'corpus build --snippet-context'")
+	viewCmd.PersistentFlags().StringArray("source_root", []string{}, "Local checkout of the repo of a project that editor links point to, "+
+		"either as {path} for all projects or {project}={path}. Defaults to the repo the corpus was built from")
+	viper.BindPFlag("source_root", viewCmd.PersistentFlags().Lookup("source_root"))
+	viewCmd.PersistentFlags().String("editor", "", fmt.Sprintf("Link matched lines to the local source file opened in this editor (%s)", strings.Join(internal.EditorNames(), ", ")))
+	viper.BindPFlag("editor", viewCmd.PersistentFlags().Lookup("editor"))
+	viewCmd.PersistentFlags().StringSlice("links", []string{}, "Link targets of matched lines: 'web' for link_template, 'editor', or the name of a link of the definition. "+
+		"The first one links the source column, and the others are appended to the line. Defaults to editor (if --editor is set) and web")
+	viper.BindPFlag("links", viewCmd.PersistentFlags().Lookup("links"))
+	viewCmd.PersistentFlags().Bool("detect_version", false, "Switch to another corpus version of a project whenever a log line matches the version_marker of the project")
 }
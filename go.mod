@@ -5,6 +5,7 @@ go 1.23.4
 require (
 	github.com/adrg/xdg v0.5.3
 	github.com/flier/gohs v1.2.3
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/htfy96/go-pcre2/v2 v2.0.0-20241218023706-27cf5b780493
 	github.com/muesli/termenv v0.15.2
 	github.com/pelletier/go-toml/v2 v2.2.3
@@ -18,7 +19,6 @@ require (
 
 require (
 	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
-	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
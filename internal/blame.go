@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phuslu/log"
+	"github.com/schollz/progressbar/v3"
+)
+
+// BlameInfo is the last commit that changed a log call.
+type BlameInfo struct {
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email,omitempty"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// parseBlamePorcelain returns the commit of each line in the output of
+// git blame --porcelain, by line number.
+func parseBlamePorcelain(out []byte) (map[int]*BlameInfo, error) {
+	lines := make(map[int]*BlameInfo)
+	commits := make(map[string]*BlameInfo)
+	var current *BlameInfo
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") {
+			// Content of the blamed line
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		if current == nil || len(key) >= 40 && isHex(key) {
+			// Header of a line: <commit> <original line> <final line> [<lines in group>]
+			fields := strings.Fields(value)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("malformed git blame header %q", line)
+			}
+			finalLine, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("malformed git blame header %q: %w", line, err)
+			}
+			if _, ok := commits[key]; !ok {
+				commits[key] = &BlameInfo{Commit: key}
+			}
+			current = commits[key]
+			lines[finalLine] = current
+			continue
+		}
+		switch key {
+		case "author":
+			current.Author = value
+		case "author-mail":
+			current.Email = strings.Trim(value, "<>")
+		case "author-time":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed git blame author-time %q: %w", value, err)
+			}
+			current.Date = time.Unix(ts, 0).UTC()
+		case "summary":
+			current.Subject = value
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// blameFile attributes each call of a single file to the newest commit that
+// changed one of its lines. Lines not committed yet are ignored.
+func blameFile(sourceTree SourceTree, filePath string, calls []*LogCall) error {
+	ranges := [][2]int{}
+	for _, call := range calls {
+		ranges = append(ranges, [2]int{call.Line, max(call.EndLine, call.Line)})
+	}
+	slices.SortFunc(ranges, func(a, b [2]int) int { return a[0] - b[0] })
+	// Overlapping ranges are merged, as git blame rejects them
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	out, err := sourceTree.Blame(filePath, merged)
+	if err != nil {
+		return err
+	}
+	lines, err := parseBlamePorcelain(out)
+	if err != nil {
+		return fmt.Errorf("error parsing git blame of %s: %w", filePath, err)
+	}
+	for _, call := range calls {
+		for line := call.Line; line <= max(call.EndLine, call.Line); line++ {
+			info, ok := lines[line]
+			// Lines not committed yet are attributed to an all-zero commit
+			if !ok || strings.Trim(info.Commit, "0") == "" {
+				continue
+			}
+			if call.Blame == nil || info.Date.After(call.Blame.Date) {
+				blame := *info
+				call.Blame = &blame
+			}
+		}
+	}
+	return nil
+}
+
+// blameCalls runs git blame on the files of all calls in parallel. Files that
+// fail to be blamed are logged and skipped.
+func blameCalls(ctx context.Context, sourceTree SourceTree, calls []LogCall, opts BuildOptions) error {
+	callsByFile := make(map[string][]*LogCall)
+	files := []string{}
+	for i := range calls {
+		filePath := calls[i].File
+		if _, ok := callsByFile[filePath]; !ok {
+			files = append(files, filePath)
+		}
+		callsByFile[filePath] = append(callsByFile[filePath], &calls[i])
+	}
+
+	fileChan := make(chan string)
+	wg := sync.WaitGroup{}
+	pbar := progressbar.Default(int64(len(files)), "git blame")
+	defer pbar.Close()
+	failed := 0
+	mu := sync.Mutex{}
+	for i := 0; i < opts.jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range fileChan {
+				if err := blameFile(sourceTree, filePath, callsByFile[filePath]); err != nil {
+					log.Warn().Msgf("Error blaming file %s: %v", filePath, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+				pbar.Add(1)
+			}
+		}()
+	}
+	for _, filePath := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		fileChan <- filePath
+	}
+	close(fileChan)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("corpus build aborted: %w", err)
+	}
+	if failed > 0 && failed == len(files) {
+		return fmt.Errorf("git blame failed on all %d files. Is the source tree a git repository?", failed)
+	}
+	if failed > 0 {
+		log.Warn().Msgf("Skipped blame of %d of %d files due to errors", failed, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeSourceTree is a SourceTree of in-memory files. Blame returns the blame
+// of the file and records the requested ranges.
+type fakeSourceTree struct {
+	files       map[string]string
+	blame       map[string]string
+	blameRanges [][2]int
+}
+
+func (t *fakeSourceTree) ListFiles() ([]string, error) {
+	return slices.Sorted(maps.Keys(t.files)), nil
+}
+
+func (t *fakeSourceTree) ReadFile(path string) ([]byte, error) {
+	content, ok := t.files[path]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+	return []byte(content), nil
+}
+
+func (t *fakeSourceTree) Revision() string { return "" }
+
+func (t *fakeSourceTree) Blame(path string, ranges [][2]int) ([]byte, error) {
+	t.blameRanges = append(t.blameRanges, ranges...)
+	out, ok := t.blame[path]
+	if !ok {
+		return nil, fmt.Errorf("no blame of %s", path)
+	}
+	return []byte(out), nil
+}
+
+func (t *fakeSourceTree) Close() error { return nil }
+
+const (
+	blameCommitA = "1111111111111111111111111111111111111111"
+	blameCommitB = "2222222222222222222222222222222222222222"
+	blameCommit0 = "0000000000000000000000000000000000000000"
+)
+
+// Blame of lines 3-5 and 10: line 4 by commit B, the others by commit A, and
+// line 10 uncommitted
+var testBlamePorcelain = blameCommitA + ` 3 3 1
+author Alice
+author-mail <alice@example.com>
+author-time 1700000000
+author-tz +0000
+committer Alice
+summary Add logging
+filename a.go
+	log.Info("a")
+` + blameCommitB + ` 4 4 1
+author Bob
+author-mail <bob@example.com>
+author-time 1710000000
+summary Reword message
+previous ` + blameCommitA + ` a.go
+filename a.go
+	log.Info("b")
+` + blameCommitA + ` 5 5 1
+filename a.go
+	log.Info("c")
+` + blameCommit0 + ` 10 10 1
+author Not Committed Yet
+author-mail <not.committed.yet>
+author-time 1720000000
+summary Version of a.go from a.go
+filename a.go
+	log.Info("d")
+`
+
+func TestParseBlamePorcelain(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    map[int]string
+		wantErr bool
+	}{
+		{"empty", "", map[int]string{}, false},
+		{"repeated commits", testBlamePorcelain, map[int]string{3: blameCommitA, 4: blameCommitB, 5: blameCommitA, 10: blameCommit0}, false},
+		{"header without final line", blameCommitA + " 3\n", nil, true},
+		{"non-numeric final line", blameCommitA + " 3 x 1\n", nil, true},
+		{"malformed author-time", blameCommitA + " 3 3 1\nauthor-time soon\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := parseBlamePorcelain([]byte(tt.out))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseBlamePorcelain succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBlamePorcelain: %v", err)
+			}
+			got := map[int]string{}
+			for line, info := range lines {
+				got[line] = info.Commit
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("commits by line = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	lines, err := parseBlamePorcelain([]byte(testBlamePorcelain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BlameInfo{Commit: blameCommitA, Author: "Alice", Email: "alice@example.com", Date: time.Unix(1700000000, 0).UTC(), Subject: "Add logging"}
+	if *lines[5] != want {
+		t.Errorf("blame of line 5 = %+v, want %+v", *lines[5], want)
+	}
+}
+
+func TestBlameFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		calls      []LogCall
+		wantCommit []string
+		wantRanges [][2]int
+	}{
+		{"single line", []LogCall{{Line: 3}}, []string{blameCommitA}, [][2]int{{3, 3}}},
+		{"newest commit of a multi-line call", []LogCall{{Line: 3, EndLine: 5}}, []string{blameCommitB}, [][2]int{{3, 5}}},
+		{"uncommitted lines are ignored", []LogCall{{Line: 10}, {Line: 5}}, []string{"", blameCommitA}, [][2]int{{5, 5}, {10, 10}}},
+		{"overlapping ranges are merged", []LogCall{{Line: 4, EndLine: 5}, {Line: 3, EndLine: 4}}, []string{blameCommitB, blameCommitB}, [][2]int{{3, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := &fakeSourceTree{blame: map[string]string{"a.go": testBlamePorcelain}}
+			calls := []*LogCall{}
+			for i := range tt.calls {
+				calls = append(calls, &tt.calls[i])
+			}
+			if err := blameFile(tree, "a.go", calls); err != nil {
+				t.Fatalf("blameFile: %v", err)
+			}
+			for i, call := range calls {
+				got := ""
+				if call.Blame != nil {
+					got = call.Blame.Commit
+				}
+				if got != tt.wantCommit[i] {
+					t.Errorf("commit of call %d = %q, want %q", i, got, tt.wantCommit[i])
+				}
+			}
+			if !slices.Equal(tree.blameRanges, tt.wantRanges) {
+				t.Errorf("blamed ranges = %v, want %v", tree.blameRanges, tt.wantRanges)
+			}
+		})
+	}
+}
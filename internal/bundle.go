@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/phuslu/log"
+	"github.com/spf13/viper"
+)
+
+// Name of the index in a bundle. It is followed by the corpus files, and
+// optionally a prebuilt Hyperscan database under hsdb/.
+const bundleIndexName = "bundle.json"
+
+const bundleFormatVersion = 1
+
+// BundleIndex describes the content of a portable corpus bundle.
+type BundleIndex struct {
+	FormatVersion int             `json:"format_version"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Entries       []ManifestEntry `json:"entries"`
+	// Name of the Hyperscan database in the bundle. Empty if not exported
+	HSDB string `json:"hsdb,omitempty"`
+	// GOOS/GOARCH the Hyperscan database was built on. It can't be used on other platforms
+	HSDBPlatform string `json:"hsdb_platform,omitempty"`
+}
+
+func currentPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// ExportOptions selects the content of a bundle written by ExportBundle.
+type ExportOptions struct {
+	// Projects to export with all of their versions. All projects are exported if empty
+	Projects []string
+	// Cached Hyperscan database to include, see Viewer.HSDBCachePath. Not included if empty
+	HSDBPath string
+}
+
+// ExportBundle writes the corpus files of all corpus directories selected by opts to w, as
+// a gzip-compressed tar archive.
+func ExportBundle(w io.Writer, opts ExportOptions) (*BundleIndex, error) {
+	// The corpus files are read under the same locks as the manifests listing them
+	unlock, err := lockLayers()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	manifest, err := readMergedManifest(false)
+	if err != nil {
+		return nil, err
+	}
+	// Projects are checked before any corpus file is read
+	for _, project := range opts.Projects {
+		if err := ValidateProjectName(project); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(manifest.Projects(), project) {
+			return nil, fmt.Errorf("project %s not found", project)
+		}
+	}
+	index := &BundleIndex{FormatVersion: bundleFormatVersion, CreatedAt: time.Now(), Entries: []ManifestEntry{}}
+	files := make(map[string][]byte)
+	for _, entry := range manifest.Entries {
+		if len(opts.Projects) > 0 && !slices.Contains(opts.Projects, entry.Project) {
+			continue
+		}
+		if err := ValidateProjectName(entry.Project); err != nil {
+			return nil, err
+		}
+		filePath := entry.Path()
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading corpus file %q: %w", filePath, err)
+		}
+		if entry.Checksum != "" && checksum(data) != entry.Checksum {
+			return nil, fmt.Errorf("checksum mismatch of corpus file %q. It may be corrupted", filePath)
+		}
+		entry.Checksum = checksum(data)
+		index.Entries = append(index.Entries, entry)
+		files[entry.File] = data
+	}
+	if opts.HSDBPath != "" {
+		data, err := os.ReadFile(opts.HSDBPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading hyperscan database %s: %w", opts.HSDBPath, err)
+		}
+		index.HSDB = path.Join("hsdb", filepath.Base(opts.HSDBPath))
+		index.HSDBPlatform = currentPlatform()
+		files[index.HSDB] = data
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling bundle index: %w", err)
+	}
+	writeEntry := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: index.CreatedAt}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := writeEntry(bundleIndexName, indexData); err != nil {
+		return nil, fmt.Errorf("error writing bundle: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := writeEntry(name, files[name]); err != nil {
+			return nil, fmt.Errorf("error writing bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error writing bundle: %w", err)
+	}
+	return index, nil
+}
+
+// readBundle reads the index and all files of a bundle written by ExportBundle.
+func readBundle(r io.Reader) (*BundleIndex, map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decompressing bundle: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("error reading bundle: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s from bundle: %w", header.Name, err)
+		}
+		files[header.Name] = data
+	}
+	indexData, ok := files[bundleIndexName]
+	if !ok {
+		return nil, nil, fmt.Errorf("not a corpus bundle: %s is missing", bundleIndexName)
+	}
+	var index BundleIndex
+	if err := json.Unmarshal(indexData, &index); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling bundle index: %w", err)
+	}
+	if index.FormatVersion > bundleFormatVersion {
+		return nil, nil, fmt.Errorf("bundle has format version %d, but this build of logalign only supports up to %d", index.FormatVersion, bundleFormatVersion)
+	}
+	return &index, files, nil
+}
+
+// bundleCorpusFile decodes the corpus file of an entry of a bundle.
+func bundleCorpusFile(files map[string][]byte, entry ManifestEntry) (*CorpusFile, error) {
+	data, ok := files[entry.File]
+	if !ok {
+		return nil, fmt.Errorf("corpus file %s is missing from the bundle", entry.File)
+	}
+	if checksum(data) != entry.Checksum {
+		return nil, fmt.Errorf("checksum mismatch of corpus file %s in the bundle. It may be corrupted", entry.File)
+	}
+	return decodeCorpusFile(data, entry.File)
+}
+
+// ReadExportedCorpus reads the corpus files stored outside the corpus
+// directories, either in a single corpus file or in a bundle.
+func ReadExportedCorpus(filePath string) ([]*CorpusFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %w", filePath, err)
+	}
+	if bytes.HasPrefix(data, []byte(corpusFileMagic)) {
+		corpusFile, err := decodeCorpusFile(data, filePath)
+		if err != nil {
+			return nil, err
+		}
+		return []*CorpusFile{corpusFile}, nil
+	}
+	index, files, err := readBundle(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a corpus file nor a bundle: %w", filePath, err)
+	}
+	corpusFiles := []*CorpusFile{}
+	for _, entry := range index.Entries {
+		corpusFile, err := bundleCorpusFile(files, entry)
+		if err != nil {
+			return nil, err
+		}
+		corpusFiles = append(corpusFiles, corpusFile)
+	}
+	return corpusFiles, nil
+}
+
+// ImportResult lists what ImportBundle did with each corpus file of a bundle.
+type ImportResult struct {
+	Imported []ManifestEntry
+	// Already in CorpusDir, and not replaced
+	Skipped []ManifestEntry
+	// Whether the Hyperscan database of the bundle was installed into the cache
+	HSDBInstalled bool
+}
+
+// ImportBundle adds the corpus files of a bundle written by ExportBundle to
+// CorpusDir. Existing versions of a project are only replaced if replace is set.
+func ImportBundle(r io.Reader, replace bool) (*ImportResult, error) {
+	index, files, err := readBundle(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{}
+	err = updateManifest(func(m *CorpusManifest) error {
+		for _, entry := range index.Entries {
+			corpusFile, err := bundleCorpusFile(files, entry)
+			if err != nil {
+				return err
+			}
+			exists := slices.ContainsFunc(m.Entries, func(e ManifestEntry) bool {
+				return e.Project == corpusFile.Project && e.Version == corpusFile.Version
+			})
+			if exists && !replace {
+				result.Skipped = append(result.Skipped, entry)
+				continue
+			}
+			// Written to its canonical path rather than the name given by the bundle. The
+			// files of all entries replace the live ones together with the manifest
+			filePath, err := corpusFilePath(corpusFile)
+			if err != nil {
+				return fmt.Errorf("error importing corpus file %s: %w", entry.File, err)
+			}
+			if err := m.stageFile(filePath, files[entry.File]); err != nil {
+				return err
+			}
+			newEntry := newManifestEntry(corpusFile)
+			newEntry.Checksum = entry.Checksum
+			m.put(newEntry)
+			result.Imported = append(result.Imported, newEntry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if index.HSDB != "" {
+		if index.HSDBPlatform != currentPlatform() {
+			log.Warn().Msgf("Not installing the hyperscan database of the bundle, which was built on %s", index.HSDBPlatform)
+			return result, nil
+		}
+		cacheDir := viper.GetString("cache_dir")
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(cacheDir, path.Base(index.HSDB)), files[index.HSDB]); err != nil {
+			return nil, fmt.Errorf("error installing hyperscan database: %w", err)
+		}
+		result.HSDBInstalled = true
+	}
+	return result, nil
+}
@@ -2,15 +2,21 @@ package internal
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"maps"
+	"net/url"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/pelletier/go-toml/v2"
 	"github.com/phuslu/log"
 	"github.com/schollz/progressbar/v3"
 	sitter "github.com/smacker/go-tree-sitter"
@@ -38,6 +44,8 @@ type LogCallDefinition struct {
 	LinkTemplate        string            `json:"link_template" toml:"link_template"`
 	StripTailingNewLine bool              `json:"strip_tailing_newline" toml:"strip_tailing_newline,omitempty"`
 	CustomAttrs         map[string]string `json:"custom_attrs,omitempty" toml:"custom_attrs,omitempty"`
+	// Additional link templates by name, e.g. blame = '...'. See ViewConfig.Links
+	Links map[string]string `json:"links,omitempty" toml:"links,omitempty"`
 	// Only populated in LogCallDefinitionFile
 	CompiledQuery *sitter.Query `json:"-" toml:"omitempty"`
 }
@@ -57,26 +65,103 @@ func (def *LogCallDefinition) Compile() error {
 	if err != nil {
 		return fmt.Errorf("invalid %s query in %v: %s", def.Language, def, err)
 	}
+	if _, err := ParseLinkTemplate(def.LinkTemplate, def.CustomAttrs); err != nil {
+		query.Close()
+		return fmt.Errorf("invalid link_template of definition %s: %w", def.ID, err)
+	}
+	for name, template := range def.Links {
+		if name == LinkTargetWeb || name == LinkTargetEditor {
+			query.Close()
+			return fmt.Errorf("link name %q of definition %s is reserved", name, def.ID)
+		}
+		if _, err := ParseLinkTemplate(template, def.CustomAttrs); err != nil {
+			query.Close()
+			return fmt.Errorf("invalid link %s of definition %s: %w", name, def.ID, err)
+		}
+	}
 	def.CompiledQuery = query
 	return nil
 
 }
 
 type LogCall struct {
-	Project       string   `json:"project"`
-	File          string   `json:"file"`
+	// Stable ID of the call site, see assignCallIDs
+	ID      string `json:"id,omitempty"`
+	Project string `json:"project"`
+	File    string `json:"file"`
+	// Directory of the definition file the call was extracted with
+	Root string `json:"root,omitempty"`
+	// Span of the call in the source. Lines and columns are 1-indexed and inclusive, columns count bytes
 	Line          int      `json:"line"`
+	Column        int      `json:"column,omitempty"`
+	EndLine       int      `json:"end_line,omitempty"`
+	EndColumn     int      `json:"end_column,omitempty"`
 	DefinitionID  string   `json:"definition_id"`
 	Method        string   `json:"method"`
 	FormatString  string   `json:"format_string"`
 	ArgumentExprs []string `json:"argument_exprs"`
+	// Captured by the optional @level and @logger captures
+	Level  string `json:"level,omitempty"`
+	Logger string `json:"logger,omitempty"`
+	// Functions, methods and classes enclosing the call, joined by "."
+	Function string `json:"function,omitempty"`
+	// Source around the call. Only captured if BuildOptions.SnippetContext is set
+	Snippet *SourceSnippet `json:"snippet,omitempty"`
+	// Only captured if BuildOptions.Blame is set
+	Blame *BlameInfo `json:"blame,omitempty"`
+}
+
+// SourceSnippet is a range of source lines starting at StartLine (1-indexed).
+type SourceSnippet struct {
+	StartLine int      `json:"start_line"`
+	Lines     []string `json:"lines"`
+}
+
+// newSourceSnippet returns lines [startLine - context, endLine + context] of a source file.
+func newSourceSnippet(lines []string, startLine int, endLine int, context int) *SourceSnippet {
+	first := max(startLine-context, 1)
+	last := min(endLine+context, len(lines))
+	if first > last {
+		return nil
+	}
+	return &SourceSnippet{StartLine: first, Lines: slices.Clone(lines[first-1 : last])}
+}
+
+// callNode returns the smallest node enclosing all captures of a match, which
+// is usually the call expression itself.
+func callNode(captures []sitter.QueryCapture) *sitter.Node {
+	startByte, endByte := captures[0].Node.StartByte(), captures[0].Node.EndByte()
+	for _, capture := range captures[1:] {
+		startByte = min(startByte, capture.Node.StartByte())
+		endByte = max(endByte, capture.Node.EndByte())
+	}
+	node := captures[0].Node
+	for node.StartByte() > startByte || node.EndByte() < endByte {
+		node = node.Parent()
+	}
+	return node
 }
 
 type LogCallDefinitionFile struct {
-	Project           string              `toml:"project"`
-	SourceRegex       string              `toml:"source_regex,omitempty"`
-	IgnoreSourceRegex string              `toml:"ignore_source_regex,omitempty"`
-	Definitions       []LogCallDefinition `toml:"definitions"`
+	Project           string `toml:"project"`
+	SourceRegex       string `toml:"source_regex,omitempty"`
+	IgnoreSourceRegex string `toml:"ignore_source_regex,omitempty"`
+	// A regex matching a log line that announces the version of the running program,
+	// e.g. a startup banner. The version is taken from the named group "version".
+	VersionMarker string `toml:"version_marker,omitempty"`
+	// Directories with their own definition files, relative to this one.
+	// Each of them applies to its own subtree.
+	Roots []string `toml:"roots,omitempty"`
+	// Use all nested definition files in the repo. Only read from the repo root.
+	DiscoverNested bool `toml:"discover_nested,omitempty"`
+	// Also apply the definitions of the enclosing definition file. Only read from nested definition files.
+	InheritDefinitions bool `toml:"inherit_definitions,omitempty"`
+	// Settings of 'logalign view' for the logs of the project
+	View ViewProfile `toml:"view,omitempty"`
+	// Hosting service of the git remote the link_template of definitions without
+	// one is derived for, e.g. "gitlab" or "none". Detected from the remote URL if empty.
+	LinkStyle   string              `toml:"link_style,omitempty"`
+	Definitions []LogCallDefinition `toml:"definitions"`
 }
 
 func SampleLogCallDefinitionFile() LogCallDefinitionFile {
@@ -84,6 +169,7 @@ func SampleLogCallDefinitionFile() LogCallDefinitionFile {
 		Project:           "linux",
 		SourceRegex:       "drivers/net/.*\\.c",
 		IgnoreSourceRegex: "generated\\.c$",
+		VersionMarker:     `Linux version (?P<version>\S+)`,
 		Definitions: []LogCallDefinition{
 			{
 				ID: "printk",
@@ -94,7 +180,7 @@ func SampleLogCallDefinitionFile() LogCallDefinitionFile {
   arguments: (argument_list
     "("
     (concatenated_string
-      (identifier) @loglevel
+      (identifier) @level
       (string_literal
         _*
         [(string_content)
@@ -119,6 +205,22 @@ func SampleLogCallDefinitionFile() LogCallDefinitionFile {
 	}
 }
 
+// CompileVersionMarker compiles a version_marker regex and checks that it
+// captures the version. An empty marker compiles to nil.
+func CompileVersionMarker(marker string) (*regexp.Regexp, error) {
+	if marker == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(marker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version_marker %q: %w", marker, err)
+	}
+	if re.SubexpIndex("version") < 0 {
+		return nil, fmt.Errorf("version_marker %q must contain a named group \"version\"", marker)
+	}
+	return re, nil
+}
+
 func (c *LogCallDefinitionFile) Close() {
 	for _, def := range c.Definitions {
 		def.Close()
@@ -129,6 +231,33 @@ type CorpusFile struct {
 	Project     string              `json:"project"`
 	Definitions []LogCallDefinition `json:"definitions,omitempty"`
 	Calls       []LogCall           `json:"calls,omitempty"`
+	// Git commit the corpus was built from. Empty if built from a working directory.
+	Revision string `json:"revision,omitempty"`
+	// Version tag of this corpus. A project may have one corpus file per version.
+	Version       string    `json:"version,omitempty"`
+	VersionMarker string    `json:"version_marker,omitempty"`
+	BuiltAt       time.Time `json:"built_at"`
+	// Hash of Definitions and Calls
+	ContentHash string     `json:"content_hash,omitempty"`
+	Provenance  Provenance `json:"provenance"`
+	// The [view] section of the definition file of the project
+	View *ViewProfile `json:"view,omitempty"`
+}
+
+// ViewProfile is the [view] section of a logcall definition file, with
+// settings of 'logalign view' for the logs of the project. See the fields of
+// ViewConfig of the same names, and ViewConfig.ApplyProfile.
+type ViewProfile struct {
+	LinePrefix     string `json:"line_prefix,omitempty" toml:"line_prefix,omitempty"`
+	StartPos       int    `json:"start_pos,omitempty" toml:"start_pos,omitempty"`
+	StartCharPos   string `json:"start_char_pos,omitempty" toml:"start_char_pos,omitempty"`
+	EnvelopeRegex  string `json:"envelope_regex,omitempty" toml:"envelope_regex,omitempty"`
+	SourceLocation string `json:"source_location,omitempty" toml:"source_location,omitempty"`
+	// Unset if nil, as 0 is a valid setting
+	MinMatchChars     *int     `json:"min_match_chars,omitempty" toml:"min_match_chars,omitempty"`
+	MinMatchWordChars *int     `json:"min_match_word_chars,omitempty" toml:"min_match_word_chars,omitempty"`
+	MinMatchedRatio   *float64 `json:"min_matched_ratio,omitempty" toml:"min_matched_ratio,omitempty"`
+	SourceColumnWidth *int     `json:"source_column_width,omitempty" toml:"source_column_width,omitempty"`
 }
 
 func (c *CorpusFile) String() string {
@@ -140,270 +269,587 @@ func (c *CorpusFile) String() string {
 }
 
 func (c *CorpusFile) GetPath() string {
-	return filepath.Join(CorpusDir, fmt.Sprintf("%s%s.json", CorpusFilePrefix, c.Project))
+	if c.Version == "" {
+		return filepath.Join(CorpusDir, fmt.Sprintf("%s%s%s", CorpusFilePrefix, c.Project, CorpusFileSuffix))
+	}
+	return filepath.Join(CorpusDir, fmt.Sprintf("%s%s@%s%s", CorpusFilePrefix, c.Project, url.PathEscape(c.Version), CorpusFileSuffix))
 }
+
+// Save atomically writes the corpus file to CorpusDir and adds it to the manifest.
 func (c *CorpusFile) Save() error {
 	log.Info().Msgf("Saving corpus file for project %s", c.Project)
-	filePath := c.GetPath()
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshalling corpus file: %w", err)
-	}
-	err = os.WriteFile(filePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("error writing corpus file: %w", err)
-	}
-	return nil
+	return saveCorpusFiles([]*CorpusFile{c})
+}
+
+// ProjectCorpus is a map of version tags to the corpus files of a single project.
+// A corpus built without a version is stored under the empty tag.
+type ProjectCorpus map[string]CorpusFile
+
+// Versions returns all version tags of the project, oldest build first.
+func (pc ProjectCorpus) Versions() []string {
+	versions := make([]string, 0, len(pc))
+	for version := range pc {
+		versions = append(versions, version)
+	}
+	slices.SortFunc(versions, func(a, b string) int {
+		if c := pc[a].BuiltAt.Compare(pc[b].BuiltAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return versions
+}
+
+// Latest returns the most recently built corpus file of the project.
+func (pc ProjectCorpus) Latest() CorpusFile {
+	versions := pc.Versions()
+	return pc[versions[len(versions)-1]]
 }
 
 // Corpus is a map of project names to their corresponding corpus files.
-type Corpus map[string]CorpusFile
+type Corpus map[string]ProjectCorpus
 
 func NewCorpus() Corpus {
 	return make(Corpus)
 }
 
 func (c Corpus) AddCorpusFile(file *CorpusFile) {
-	c[file.Project] = *file
+	if _, ok := c[file.Project]; !ok {
+		c[file.Project] = make(ProjectCorpus)
+	}
+	c[file.Project][file.Version] = *file
 }
 
-var GlobalCorpus Corpus
-
-func ReadCorpus() (Corpus, error) {
+// Lookup returns the corpus file of a project at the given version.
+// An empty version selects the latest build.
+func (c Corpus) Lookup(project string, version string) (CorpusFile, bool) {
+	pc, ok := c[project]
+	if !ok || len(pc) == 0 {
+		return CorpusFile{}, false
+	}
+	if version == "" {
+		return pc.Latest(), true
+	}
+	file, ok := pc[version]
+	return file, ok
+}
 
-	if CorpusDir == "" {
-		return nil, fmt.Errorf("corpus directory not set")
-	}
-	log.Info().Msgf("Reading corpus from %s", CorpusDir)
-	corpus := NewCorpus()
-	files, err := os.ReadDir(CorpusDir)
-	if err != nil {
-		return nil, fmt.Errorf("error reading corpus directory: %w", err)
+// SelectVersion returns the corpus file of project at the version selected by
+// versions, see ViewConfig.Versions. If a version given for all projects isn't
+// built for project, its latest build is returned and fellBack is set. Only a
+// missing version given for project itself is an error.
+func (c Corpus) SelectVersion(project string, versions map[string]string) (file CorpusFile, fellBack bool, err error) {
+	version, explicit := versions[project]
+	if !explicit {
+		version = versions[""]
+	}
+	if file, ok := c.Lookup(project, version); ok {
+		return file, false, nil
+	}
+	if !explicit {
+		if file, ok := c.Lookup(project, ""); ok {
+			return file, true, nil
+		}
 	}
-	for _, file := range files {
-		if !strings.HasPrefix(file.Name(), CorpusFilePrefix) {
+	return CorpusFile{}, false, fmt.Errorf("version %q of project %s not found. Available versions: %q", version, project, c[project].Versions())
+}
+
+// ViewProfile returns the [view] profile of the selected projects at their
+// default versions, see ViewConfig.ProjectFilter and ViewConfig.Versions. If
+// several projects have one, the first project by name wins.
+func (c Corpus) ViewProfile(projectFilter []string, versions map[string]string) *ViewProfile {
+	var profile *ViewProfile
+	profileProject := ""
+	for _, project := range slices.Sorted(maps.Keys(c)) {
+		if len(projectFilter) > 0 && !slices.Contains(projectFilter, project) {
 			continue
 		}
-		filePath := filepath.Join(CorpusDir, file.Name())
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("error reading corpus file %q: %w", filePath, err)
+		file, _, err := c.SelectVersion(project, versions)
+		if err != nil || file.View == nil {
+			continue
 		}
-		var corpusFile CorpusFile
-		err = json.Unmarshal(data, &corpusFile)
-		if err != nil {
-			return nil, fmt.Errorf("error unmarshalling corpus file %q: %w", filePath, err)
+		if profile == nil {
+			profile, profileProject = file.View, project
+			log.Info().Msgf("Using the [view] profile of project %s", project)
+		} else if !reflect.DeepEqual(profile, file.View) {
+			log.Warn().Msgf("Projects %s and %s have different [view] profiles. Using the one of %s", profileProject, project, profileProject)
 		}
-		corpus.AddCorpusFile(&corpusFile)
 	}
-	return corpus, nil
+	return profile
 }
 
-func collectSourceFiles(repoRoot string, sourceRegex string, ignoreSourceRegex string) ([]string, error) {
-	sourceRegexCompiled, err := regexp.Compile(sourceRegex)
-	if err != nil {
-		return nil, fmt.Errorf("error compiling source regex: %w", err)
+// GlobalManifest indexes the corpus files in CorpusDir.
+var GlobalManifest *CorpusManifest
+
+// sourceFile is a file to extract log calls from, along with the definition
+// file that applies to it.
+type sourceFile struct {
+	Path   string
+	Config *subtreeConfig
+}
+
+// collectSourceFiles assigns each file to its innermost definition file, and
+// keeps the ones matching the source filters of that definition file.
+func collectSourceFiles(allFiles []string, configs []*subtreeConfig) []sourceFile {
+	filteredSourceFiles := []sourceFile{}
+	for _, filePath := range allFiles {
+		cfg := innermostConfig(configs, filePath)
+		if cfg.matches(filePath) {
+			filteredSourceFiles = append(filteredSourceFiles, sourceFile{Path: filePath, Config: cfg})
+		} else {
+			log.Trace().Msgf("Ignoring file %s", filePath)
+		}
+	}
+	log.Debug().Msgf("Collected %d source files", len(filteredSourceFiles))
+	return filteredSourceFiles
+}
+
+// firstErrorNode returns the first ERROR or MISSING node under node, or nil.
+func firstErrorNode(node *sitter.Node) *sitter.Node {
+	if node.IsError() || node.IsMissing() {
+		return node
+	}
+	for i := 0; i < int(node.ChildCount()); i++ {
+		if child := node.Child(i); child != nil && (child.HasError() || child.IsMissing()) {
+			if errNode := firstErrorNode(child); errNode != nil {
+				return errNode
+			}
+		}
 	}
-	ignoreSourceRegexCompiled, err := regexp.Compile(ignoreSourceRegex)
+	return nil
+}
+
+// QueryCapture is a node captured by the query of a log call definition.
+type QueryCapture struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Line    int    `json:"line"`
+}
+
+// QueryMatch is a single match of the query of a log call definition, and the
+// log call extracted from it. Dropped matches have a non-empty DropReason.
+type QueryMatch struct {
+	Captures   []QueryCapture
+	Call       LogCall
+	Parsed     ParsedFormatter
+	DropReason DropReason
+	DropDetail string
+}
+
+func (m *QueryMatch) diagnostic() Diagnostic {
+	return Diagnostic{
+		Project:      m.Call.Project,
+		DefinitionID: m.Call.DefinitionID,
+		Reason:       m.DropReason,
+		File:         m.Call.File,
+		Line:         m.Call.Line,
+		Detail:       m.DropDetail,
+	}
+}
+
+// parseSource parses a source file with the language matching its file name.
+// It returns a nil tree if the language is not supported.
+func parseSource(ctx context.Context, parser *sitter.Parser, filePath string, source []byte) (*sitter.Tree, *LanguageDef, error) {
+	langDef := GetLanguageDefByFileName(filePath)
+	if langDef == nil {
+		return nil, nil, nil
+	}
+	parser.SetLanguage(langDef.SitterLanguage)
+	tree, err := parser.ParseCtx(ctx, nil, source)
 	if err != nil {
-		return nil, fmt.Errorf("error compiling ignore source regex: %w", err)
+		return nil, nil, fmt.Errorf("error parsing file %q: %w", filePath, err)
 	}
-	filterFile := func(filePath string) bool {
-		if ignoreSourceRegex != "" && ignoreSourceRegexCompiled.MatchString(filePath) {
-			return false
-		}
-		return sourceRegex == "" || sourceRegexCompiled.MatchString(filePath)
+	return tree, langDef, nil
+}
+
+// syntaxErrorDiagnostic returns a diagnostic for the first tree-sitter ERROR
+// node of a parsed file, or nil if there is none.
+func syntaxErrorDiagnostic(tree *sitter.Tree, project string, filePath string) *Diagnostic {
+	errNode := firstErrorNode(tree.RootNode())
+	if errNode == nil {
+		return nil
+	}
+	return &Diagnostic{
+		Project: project,
+		File:    filePath,
+		Line:    int(errNode.StartPoint().Row) + 1,
+		Detail:  fmt.Sprintf("tree-sitter %s node", errNode.Type()),
 	}
-	sourceFiles := []string{}
-	if _, err := os.Stat(filepath.Join(repoRoot, ".git")); err == nil {
-		log.Debug().Msgf("Building corpus from Git repository at %s", repoRoot)
-		cmd := exec.Command("git", "-C", repoRoot, "ls-files", "--full-name")
-		out, err := cmd.Output()
-		if err != nil {
-			return nil, fmt.Errorf("error running git command: %w", err)
+}
+
+// runDefinitionQuery runs the query of def against a parsed source file, and
+// validates each match. Project, File and Root of the extracted calls are
+// copied from base.
+func runDefinitionQuery(def *LogCallDefinition, tree *sitter.Tree, source []byte, base LogCall) []QueryMatch {
+	queryMatches := []QueryMatch{}
+	langDef := GetLanguageDefByName(strings.ToLower(def.Language))
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+	cursor.Exec(def.CompiledQuery, tree.RootNode())
+	for match, ok := cursor.NextMatch(); ok; match, ok = cursor.NextMatch() {
+		match = cursor.FilterPredicates(match, source)
+		if len(match.Captures) == 0 {
+			continue
 		}
-		sourceFiles = strings.Split(string(out), "\n")
-	} else {
-		log.Debug().Msgf("Building corpus from local directory at %s", repoRoot)
-		filepath.Walk(repoRoot, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				log.Warn().Msgf("error walking directory %s: %s", path, err)
-				return err
+
+		method := ""
+		level := ""
+		logger := ""
+		formatString := ""
+		argumentExprs := []string{}
+		captures := []QueryCapture{}
+		mainCapture := match.Captures[0]
+		for _, capture := range match.Captures {
+			name := def.CompiledQuery.CaptureNameForId(capture.Index)
+			log.Trace().Msgf("Query %s Captured capture %d (name %s): %s", def.Query, capture.Index,
+				name, capture.Node.Content(source))
+			captures = append(captures, QueryCapture{
+				Name:    name,
+				Content: capture.Node.Content(source),
+				Line:    int(capture.Node.StartPoint().Row) + 1,
+			})
+			if name == "method" {
+				method = capture.Node.Content(source)
+			} else if name == "format_string" {
+				formatString += capture.Node.Content(source)
+			} else if name == "argument_expr" {
+				argumentExprs = append(argumentExprs, capture.Node.Content(source))
+			} else if name == "level" {
+				level = capture.Node.Content(source)
+			} else if name == "logger" {
+				logger = capture.Node.Content(source)
 			}
-			if !info.IsDir() {
-				path, _ = strings.CutPrefix(path, repoRoot)
-				path = strings.TrimPrefix(path, "/")
-				sourceFiles = append(sourceFiles, path)
+		}
+		if def.StripTailingNewLine {
+			formatString = strings.TrimSuffix(formatString, "\n")
+			formatString = strings.TrimSuffix(formatString, "\\n")
+		}
+		logCall := base
+		span := callNode(match.Captures)
+		logCall.Line = int(span.StartPoint().Row) + 1
+		logCall.Column = int(span.StartPoint().Column) + 1
+		logCall.EndLine = int(span.EndPoint().Row) + 1
+		// EndPoint is exclusive
+		logCall.EndColumn = int(span.EndPoint().Column)
+		logCall.Method = method
+		logCall.FormatString = formatString
+		logCall.ArgumentExprs = argumentExprs
+		logCall.Level = level
+		logCall.Logger = logger
+		if langDef != nil {
+			logCall.Function = langDef.EnclosingScope(mainCapture.Node, source)
+		}
+		logCall.DefinitionID = def.ID
+		queryMatch := QueryMatch{Captures: captures, Call: logCall}
+
+		if method == "" {
+			log.Warn().Msgf("Failed to extract method from log call from match %s at file %s", mainCapture.Node.Content(source), logCall.File)
+			queryMatch.DropReason, queryMatch.DropDetail = DropReasonNoMethod, mainCapture.Node.Content(source)
+		} else if formatString == "" {
+			log.Warn().Msgf("Failed to extract format string from log call from match %s at file %s", mainCapture.Node.Content(source), logCall.File)
+			queryMatch.DropReason, queryMatch.DropDetail = DropReasonNoFormatString, mainCapture.Node.Content(source)
+		} else if def.Syntax == LogCallSyntaxPrintflike {
+			parsed, err := ParsePrintfFormat(logCall.FormatString, "test")
+			if err != nil {
+				log.Info().Msgf("Failed to parse printf-like format string %q from %s:%d : %s", logCall.FormatString, logCall.File, logCall.Line, err)
+				queryMatch.DropReason, queryMatch.DropDetail = DropReasonInvalidFormat, err.Error()
+			} else if parsed.ArgCnt != len(logCall.ArgumentExprs) {
+				log.Info().Msgf("Argument count mismatch in log call %v: expected %d, got %d", logCall, parsed.ArgCnt, len(logCall.ArgumentExprs))
+				queryMatch.DropReason = DropReasonArgCountMismatch
+				queryMatch.DropDetail = fmt.Sprintf("%q expects %d arguments, got %d", logCall.FormatString, parsed.ArgCnt, len(logCall.ArgumentExprs))
 			}
-			return nil
-		})
-	}
-	filteredSourceFiles := []string{}
-	for _, filePath := range sourceFiles {
-		if filterFile(filePath) {
-			filteredSourceFiles = append(filteredSourceFiles, filePath)
+			queryMatch.Parsed = parsed
 		} else {
-			log.Trace().Msgf("Ignoring file %s", filePath)
+			log.Info().Msgf("Unsupported syntax %q of log call %v", def.Syntax, logCall)
+			queryMatch.DropReason, queryMatch.DropDetail = DropReasonUnsupportedSyntax, string(def.Syntax)
 		}
+		if queryMatch.DropReason == "" {
+			log.Trace().Msgf("Found log call in match %s at file %s: %+v", mainCapture.Node.Content(source), logCall.File, logCall)
+		}
+		queryMatches = append(queryMatches, queryMatch)
+	}
+	return queryMatches
+}
+
+// assignCallIDs sets the ID of each call of a single file to a hash of its
+// file path, enclosing function, method and format string, so that it stays
+// the same when the call moves to another line. Calls sharing all of them are
+// told apart by their order in the file, e.g. abcdef012345-2 for the second one.
+func assignCallIDs(calls []LogCall) {
+	slices.SortStableFunc(calls, func(a, b LogCall) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	seen := make(map[string]int)
+	for i := range calls {
+		hash := sha256.Sum256([]byte(strings.Join([]string{calls[i].File, calls[i].Function, calls[i].Method, calls[i].FormatString}, "\x00")))
+		id := hex.EncodeToString(hash[:6])
+		seen[id]++
+		if seen[id] > 1 {
+			id = fmt.Sprintf("%s-%d", id, seen[id])
+		}
+		calls[i].ID = id
 	}
-	log.Debug().Msgf("Collected %d source files", len(filteredSourceFiles))
-	return filteredSourceFiles, nil
 }
 
-func extractLogCalls(repoRoot string, filePath string, project string, definitions []LogCallDefinition) ([]LogCall, error) {
-	parser := sitter.NewParser()
-	defer parser.Close()
-	fullPath := filepath.Join(repoRoot, filePath)
-	matchedDefinitions := make([]*LogCallDefinition, 0)
-	log.Trace().Msgf("Processing file %s", fullPath)
+// extractLogCalls runs all matching definitions against a single source file,
+// and reports each match that is dropped along the way.
+// The parser is owned by the calling worker and reused across files.
+func extractLogCalls(ctx context.Context, parser *sitter.Parser, sourceTree SourceTree, file sourceFile, snippetContext int) ([]LogCall, []Diagnostic, error) {
+	filePath := file.Path
+	project := file.Config.Project
+	log.Trace().Msgf("Processing file %s", filePath)
 	logCalls := []LogCall{}
+	diags := []Diagnostic{}
 
-	langDef := GetLanguageDefByFileName(filePath)
-	if langDef == nil {
+	if GetLanguageDefByFileName(filePath) == nil {
 		log.Info().Msgf("Language definition for file %s not found", filePath)
-		return logCalls, nil
+		return logCalls, diags, nil
 	}
-
-	parser.SetLanguage(langDef.SitterLanguage)
-	source, err := os.ReadFile(fullPath)
+	source, err := sourceTree.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file %q: %w", fullPath, err)
+		return nil, nil, fmt.Errorf("error reading file %q: %w", filePath, err)
 	}
-	tree, err := parser.ParseCtx(context.Background(), nil, source)
+	tree, langDef, err := parseSource(ctx, parser, filePath, source)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing file %q: %w", fullPath, err)
+		return nil, nil, err
 	}
-	for _, definition := range definitions {
-		if strings.EqualFold(definition.Language, langDef.Name) {
-			matchedDefinitions = append(matchedDefinitions, &definition)
-		}
+	defer tree.Close()
+	if diag := syntaxErrorDiagnostic(tree, project, filePath); diag != nil {
+		log.Info().Msgf("Syntax error in file %s:%d", filePath, diag.Line)
+		diags = append(diags, *diag)
 	}
-	for _, matchedDef := range matchedDefinitions {
-		cursor := sitter.NewQueryCursor()
-		defer cursor.Close()
-		cursor.Exec(matchedDef.CompiledQuery, tree.RootNode())
-		for match, ok := cursor.NextMatch(); ok; match, ok = cursor.NextMatch() {
-			match = cursor.FilterPredicates(match, source)
-			if len(match.Captures) == 0 {
+	base := LogCall{
+		Project: project,
+		File:    filePath,
+		Root:    file.Config.Root,
+	}
+	var sourceLines []string
+	if snippetContext > 0 {
+		sourceLines = strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")
+	}
+	for _, definition := range file.Config.definitions() {
+		if !strings.EqualFold(definition.Language, langDef.Name) {
+			continue
+		}
+		for _, queryMatch := range runDefinitionQuery(&definition, tree, source, base) {
+			if queryMatch.DropReason != "" {
+				diags = append(diags, queryMatch.diagnostic())
 				continue
 			}
+			if snippetContext > 0 {
+				queryMatch.Call.Snippet = newSourceSnippet(sourceLines, queryMatch.Call.Line, queryMatch.Call.EndLine, snippetContext)
+			}
+			logCalls = append(logCalls, queryMatch.Call)
+		}
+	}
+	assignCallIDs(logCalls)
+	return logCalls, diags, nil
+}
+
+// BuildOptions controls how BuildCorpusFromRepo schedules and reports its work.
+type BuildOptions struct {
+	// Number of files processed in parallel. Defaults to the number of CPUs when <= 0.
+	Jobs int
+	// Abort the whole build on the first file that fails to be processed.
+	// Otherwise failing files are logged and skipped.
+	FailFast bool
+	// Git revision to read the sources from instead of the working directory.
+	Revision string
+	// Version tag of the built corpus.
+	Version string
+	// Number of source lines around each call to store as its snippet. No snippets are stored if 0.
+	SnippetContext int
+	// Run git blame on every call
+	Blame bool
+}
+
+func (opts BuildOptions) jobs() int {
+	if opts.Jobs <= 0 {
+		return runtime.NumCPU()
+	}
+	return opts.Jobs
+}
+
+type extractResult struct {
+	filePath string
+	calls    []LogCall
+	diags    []Diagnostic
+	err      error
+}
+
+// extractAll fans files out to a fixed pool of workers, each owning a single
+// tree-sitter parser. It returns early with an error when ctx is cancelled, or
+// on the first failing file if opts.FailFast is set.
+func extractAll(ctx context.Context, sourceTree SourceTree, files []sourceFile, opts BuildOptions) ([]LogCall, BuildReport, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-			method := ""
-			formatString := ""
-			argumentExprs := []string{}
-			mainCapture := match.Captures[0]
-			for _, capture := range match.Captures {
-				log.Trace().Msgf("Query %s Captured capture %d (name %s): %s", matchedDef.Query, capture.Index,
-					matchedDef.CompiledQuery.CaptureNameForId(capture.Index),
-					capture.Node.Content(source))
-				if matchedDef.CompiledQuery.CaptureNameForId(capture.Index) == "method" {
-					method = capture.Node.Content(source)
-				} else if matchedDef.CompiledQuery.CaptureNameForId(capture.Index) == "format_string" {
-					formatString += capture.Node.Content(source)
-				} else if matchedDef.CompiledQuery.CaptureNameForId(capture.Index) == "argument_expr" {
-					argumentExprs = append(argumentExprs, capture.Node.Content(source))
+	fileChan := make(chan sourceFile)
+	resultChan := make(chan extractResult)
+	wg := sync.WaitGroup{}
+	for i := 0; i < opts.jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parser := sitter.NewParser()
+			defer parser.Close()
+			for file := range fileChan {
+				logCalls, diags, err := extractLogCalls(ctx, parser, sourceTree, file, opts.SnippetContext)
+				select {
+				case resultChan <- extractResult{filePath: file.Path, calls: logCalls, diags: diags, err: err}:
+				case <-ctx.Done():
+					return
 				}
 			}
-			if method == "" {
-				log.Warn().Msgf("Failed to extract method from log call from match %s at file %s", mainCapture.Node.Content(source), fullPath)
-				continue
-			}
-			if formatString == "" {
-				log.Warn().Msgf("Failed to extract format string from log call from match %s at file %s", mainCapture.Node.Content(source), fullPath)
-				continue
-			}
-			if matchedDef.StripTailingNewLine {
-				formatString = strings.TrimSuffix(formatString, "\n")
-				formatString = strings.TrimSuffix(formatString, "\\n")
+		}()
+	}
+	go func() {
+		defer close(fileChan)
+		for _, file := range files {
+			select {
+			case fileChan <- file:
+			case <-ctx.Done():
+				return
 			}
-			logCalls = append(logCalls, LogCall{
-				Project:       project,
-				File:          filePath,
-				Line:          int(mainCapture.Node.StartPoint().Row) + 1,
-				Method:        method,
-				FormatString:  formatString,
-				ArgumentExprs: argumentExprs,
-				DefinitionID:  matchedDef.ID,
-			})
-			log.Trace().Msgf("Found log call in match %s at file %s: %+v", mainCapture.Node.Content(source), fullPath, logCalls[len(logCalls)-1])
 		}
-	}
+	}()
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
 
-	validatedLogCalls := make([]LogCall, 0)
-	// Validate logCalls
-	definitionsMap := make(map[string]*LogCallDefinition, 0)
-	for _, definition := range definitions {
-		definitionsMap[definition.ID] = &definition
-	}
-	for _, logCall := range logCalls {
-		matchedDef := definitionsMap[logCall.DefinitionID]
-		if matchedDef.Syntax == LogCallSyntaxPrintflike {
-			parsed, err := ParsePrintfFormat(logCall.FormatString, "test")
-			if err != nil {
-				log.Info().Msgf("Failed to parse printf-like format string %q from %s:%d : %s", logCall.FormatString, logCall.File, logCall.Line, err)
+	pbar := progressbar.Default(int64(len(files)))
+	defer pbar.Close()
+	calls := []LogCall{}
+	report := BuildReport{FilesScanned: len(files)}
+	for result := range resultChan {
+		pbar.Add(1)
+		if result.err != nil {
+			if ctx.Err() != nil {
+				// Errors caused by cancellation are reported below
 				continue
 			}
-			if parsed.ArgCnt != len(logCall.ArgumentExprs) {
-				log.Info().Msgf("Argument count mismatch in log call %v: expected %d, got %d", logCall, parsed.ArgCnt, len(logCall.ArgumentExprs))
-				continue
+			if opts.FailFast {
+				cancel()
+				return nil, report, fmt.Errorf("error extracting log calls from file %s: %w", result.filePath, result.err)
 			}
-			validatedLogCalls = append(validatedLogCalls, logCall)
+			log.Error().Msgf("Error extracting log calls from file %s: %v", result.filePath, result.err)
+			report.FilesFailed++
+			continue
 		}
+		calls = append(calls, result.calls...)
+		report.addCalls(result.calls)
+		report.addDiagnostics(result.diags)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, report, fmt.Errorf("corpus build aborted: %w", err)
+	}
+	if report.FilesFailed > 0 {
+		log.Warn().Msgf("Skipped %d of %d files due to errors", report.FilesFailed, len(files))
 	}
-	return validatedLogCalls, nil
+	return calls, report, nil
 }
 
-func BuildCorpusFromRepo(repoRoot string) (CorpusFile, error) {
-	logCallDefinitionFile := LogCallDefinitionFile{
-		SourceRegex:       "",
-		IgnoreSourceRegex: "",
-		Definitions:       make([]LogCallDefinition, 0),
+// BuildCorpusFromRepo extracts all log calls from repoRoot according to its
+// logcall definition files, and returns one corpus file per project along
+// with a report of dropped matches. The corpus files are only valid when err
+// is nil; a cancelled ctx never yields a partially built corpus.
+func BuildCorpusFromRepo(ctx context.Context, repoRoot string, opts BuildOptions) ([]CorpusFile, BuildReport, error) {
+	sourceTree, err := OpenSourceTree(repoRoot, opts.Revision)
+	if err != nil {
+		return nil, BuildReport{}, fmt.Errorf("error opening source tree: %w", err)
 	}
-	logCallDefinitionFilePath := filepath.Join(repoRoot, LogCallDefinitionFileName)
-	if _, err := os.Stat(logCallDefinitionFilePath); err != nil {
-		return CorpusFile{}, fmt.Errorf("error reading logcall definition file: %w", err)
+	defer sourceTree.Close()
+	allFiles, err := sourceTree.ListFiles()
+	if err != nil {
+		return nil, BuildReport{}, fmt.Errorf("error collecting source files: %w", err)
 	}
-	data, err := os.ReadFile(logCallDefinitionFilePath)
+	configs, err := loadWorkspaceConfigs(sourceTree, allFiles)
 	if err != nil {
-		return CorpusFile{}, fmt.Errorf("error reading logcall definition file: %w", err)
+		return nil, BuildReport{}, err
 	}
-	if err := toml.Unmarshal(data, &logCallDefinitionFile); err != nil {
-		return CorpusFile{}, fmt.Errorf("error unmarshalling logcall definition file: %w", err)
+	defer closeSubtreeConfigs(configs)
+	files := collectSourceFiles(allFiles, configs)
+	calls, report, err := extractAll(ctx, sourceTree, files, opts)
+	if err != nil {
+		return nil, report, err
 	}
-	for i := range logCallDefinitionFile.Definitions {
-		if err = logCallDefinitionFile.Definitions[i].Compile(); err != nil {
-			return CorpusFile{}, fmt.Errorf("invalid log call definition: %w", err)
+	if opts.Blame {
+		if err := blameCalls(ctx, sourceTree, calls, opts); err != nil {
+			return nil, report, err
 		}
 	}
-	defer logCallDefinitionFile.Close()
-	files, err := collectSourceFiles(repoRoot, logCallDefinitionFile.SourceRegex, logCallDefinitionFile.IgnoreSourceRegex)
-	if err != nil {
-		return CorpusFile{}, fmt.Errorf("error collecting source files: %w", err)
+	for _, cfg := range configs {
+		// Definitions without any match show up in the report as well
+		for _, def := range cfg.definitions() {
+			report.definitionReport(cfg.Project, def.ID)
+		}
 	}
-	pbar := progressbar.Default(int64(len(files)))
-	completeChan := make(chan []LogCall)
-	for _, file := range files {
-		go func(filePath string) {
-			logCalls, err := extractLogCalls(repoRoot, filePath, logCallDefinitionFile.Project, logCallDefinitionFile.Definitions)
-			pbar.Add(1)
-			if err != nil {
-				log.Error().Msgf("Error extracting log calls from file %s: %v", filePath, err)
-				completeChan <- []LogCall{}
-			} else {
-				completeChan <- logCalls
+
+	// Calls are sorted so that building the same sources gives the same corpus
+	slices.SortFunc(calls, func(a, b LogCall) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column - b.Column
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	builtAt := time.Now()
+	provenance := newProvenance(repoRoot, sourceTree, configs)
+	corpusFiles := []CorpusFile{}
+	projectIndex := make(map[string]int)
+	projectConfigs := make(map[string][]*subtreeConfig)
+	for _, cfg := range configs {
+		if _, ok := projectIndex[cfg.Project]; !ok {
+			projectIndex[cfg.Project] = len(corpusFiles)
+			corpusFiles = append(corpusFiles, CorpusFile{
+				Project:    cfg.Project,
+				Calls:      []LogCall{},
+				Revision:   sourceTree.Revision(),
+				Version:    opts.Version,
+				BuiltAt:    builtAt,
+				Provenance: provenance,
+			})
+		}
+		corpusFile := &corpusFiles[projectIndex[cfg.Project]]
+		if corpusFile.VersionMarker == "" {
+			corpusFile.VersionMarker = cfg.VersionMarker
+		}
+		if corpusFile.View == nil && cfg.View != (ViewProfile{}) {
+			corpusFile.View = &cfg.View
+		}
+		projectConfigs[cfg.Project] = append(projectConfigs[cfg.Project], cfg)
+	}
+	for i := range corpusFiles {
+		corpusFiles[i].Definitions, err = mergeProjectDefinitions(projectConfigs[corpusFiles[i].Project])
+		if err != nil {
+			return nil, report, err
+		}
+		linkStyle := ""
+		for _, cfg := range projectConfigs[corpusFiles[i].Project] {
+			if linkStyle == "" {
+				linkStyle = cfg.LinkStyle
 			}
-		}(file)
+		}
+		corpusFiles[i].deriveLinkTemplates(repoRoot, linkStyle)
 	}
-	corpusFile := CorpusFile{
-		Project:     logCallDefinitionFile.Project,
-		Definitions: logCallDefinitionFile.Definitions,
-		Calls:       []LogCall{},
+	for _, call := range calls {
+		corpusFile := &corpusFiles[projectIndex[call.Project]]
+		corpusFile.Calls = append(corpusFile.Calls, call)
 	}
-	completedCnt := 0
-	for completedCnt < len(files) {
-		logCalls := <-completeChan
-		corpusFile.Calls = append(corpusFile.Calls, logCalls...)
-		completedCnt++
+	for i := range corpusFiles {
+		if corpusFiles[i].ContentHash, err = corpusFiles[i].ComputeContentHash(); err != nil {
+			return nil, report, fmt.Errorf("error hashing corpus of project %s: %w", corpusFiles[i].Project, err)
+		}
 	}
-	return corpusFile, nil
+	return corpusFiles, report, nil
 }
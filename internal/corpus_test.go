@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+var callIDRe = regexp.MustCompile(`^[0-9a-f]{12}(?:-\d+)?$`)
+
+// callID returns the ID assigned to call among the other calls of its file.
+func callID(call LogCall, others ...LogCall) string {
+	calls := append([]LogCall{call}, others...)
+	assignCallIDs(calls)
+	for _, c := range calls {
+		if c.Line == call.Line && c.Column == call.Column {
+			return c.ID
+		}
+	}
+	return ""
+}
+
+func TestAssignCallIDsStability(t *testing.T) {
+	base := LogCall{File: "a.go", Line: 10, Column: 2, Function: "Serve", Method: "Infof", FormatString: "listening on %s"}
+	id := callID(base)
+	if !callIDRe.MatchString(id) {
+		t.Fatalf("ID %q is not 12 hex digits", id)
+	}
+	tests := []struct {
+		name   string
+		modify func(call *LogCall)
+		same   bool
+	}{
+		{"moved to another line", func(call *LogCall) { call.Line, call.EndLine = 50, 51 }, true},
+		{"moved to another column", func(call *LogCall) { call.Column = 8 }, true},
+		{"arguments changed", func(call *LogCall) { call.ArgumentExprs = []string{"addr"} }, true},
+		{"level changed", func(call *LogCall) { call.Level = "warn" }, true},
+		{"format changed", func(call *LogCall) { call.FormatString = "listening at %s" }, false},
+		{"method changed", func(call *LogCall) { call.Method = "Warnf" }, false},
+		{"moved to another function", func(call *LogCall) { call.Function = "Listen" }, false},
+		{"moved to another file", func(call *LogCall) { call.File = "b.go" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := base
+			tt.modify(&call)
+			if got := callID(call); (got == id) != tt.same {
+				t.Errorf("ID = %q, original ID %q, want same: %v", got, id, tt.same)
+			}
+		})
+	}
+}
+
+func TestAssignCallIDsDuplicates(t *testing.T) {
+	call := func(line int, column int, format string) LogCall {
+		return LogCall{File: "a.go", Line: line, Column: column, Method: "Println", FormatString: format}
+	}
+	// Out of order, as calls of several definitions are appended one after another
+	calls := []LogCall{
+		call(30, 1, "retrying"),
+		call(12, 1, "done"),
+		call(10, 20, "retrying"),
+		call(10, 5, "retrying"),
+	}
+	assignCallIDs(calls)
+	retrying, done := callID(call(1, 1, "retrying")), callID(call(1, 1, "done"))
+	want := []string{
+		"10:5 " + retrying,
+		"10:20 " + retrying + "-2",
+		"12:1 " + done,
+		"30:1 " + retrying + "-3",
+	}
+	for i, c := range calls {
+		if got := fmt.Sprintf("%d:%d %s", c.Line, c.Column, c.ID); got != want[i] {
+			t.Errorf("call %d = %s, want %s", i, got, want[i])
+		}
+		if !callIDRe.MatchString(c.ID) {
+			t.Errorf("ID %q has an invalid format", c.ID)
+		}
+	}
+}
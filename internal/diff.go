@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"cmp"
+	"slices"
+)
+
+// CallChange is a property of a log call that differs between two corpora.
+// Calls that only moved to other lines are not changed.
+type CallChange string
+
+const (
+	// The format string was reworded
+	CallChangeFormat CallChange = "format"
+	// Argument expressions were added, removed or rewritten
+	CallChangeArguments CallChange = "arguments"
+	// The normalized level differs
+	CallChangeLevel  CallChange = "level"
+	CallChangeMethod CallChange = "method"
+	// Moved to another file
+	CallChangeFile CallChange = "file"
+	// Moved to another function
+	CallChangeFunction CallChange = "function"
+)
+
+// CallDiffKind is how a log call differs between two corpora.
+type CallDiffKind string
+
+const (
+	CallAdded   CallDiffKind = "added"
+	CallRemoved CallDiffKind = "removed"
+	CallChanged CallDiffKind = "changed"
+)
+
+// CallDiff is a log call that was added, removed or changed.
+type CallDiff struct {
+	Kind CallDiffKind `json:"kind"`
+	// Nil if the call was added
+	Old *LogCall `json:"old,omitempty"`
+	// Nil if the call was removed
+	New     *LogCall     `json:"new,omitempty"`
+	Changes []CallChange `json:"changes,omitempty"`
+	// How similar Old and New are, between 0 and 1
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// CorpusDiff lists the differences between the calls of two corpus files.
+type CorpusDiff struct {
+	OldProject string     `json:"old_project"`
+	OldVersion string     `json:"old_version"`
+	NewProject string     `json:"new_project"`
+	NewVersion string     `json:"new_version"`
+	Unchanged  int        `json:"unchanged"`
+	Diffs      []CallDiff `json:"diffs"`
+}
+
+// Count returns the number of diffs of the given kind.
+func (d *CorpusDiff) Count(kind CallDiffKind) int {
+	count := 0
+	for _, diff := range d.Diffs {
+		if diff.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Calls whose similarity is below this are reported as removed and added
+// instead of changed
+const minCallSimilarity = 0.6
+
+// stringSimilarity returns 1 minus the edit distance of a and b relative to
+// the longer one.
+func stringSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// argumentSimilarity returns the share of argument expressions found on both sides.
+func argumentSimilarity(a []string, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	remaining := slices.Clone(b)
+	common := 0
+	for _, expr := range a {
+		if i := slices.Index(remaining, expr); i >= 0 {
+			remaining = slices.Delete(remaining, i, i+1)
+			common++
+		}
+	}
+	return float64(common) / float64(max(len(a), len(b)))
+}
+
+// locationSimilarity is 1 for calls in the same function, decreases with
+// their distance in the same file, and is 0 for calls in different files.
+func locationSimilarity(a *LogCall, b *LogCall) float64 {
+	if a.File != b.File {
+		return 0
+	}
+	if a.Function != "" && a.Function == b.Function {
+		return 1
+	}
+	distance := a.Line - b.Line
+	if distance < 0 {
+		distance = -distance
+	}
+	return 20 / float64(20+distance)
+}
+
+func callSimilarity(a *LogCall, b *LogCall) float64 {
+	return 0.6*stringSimilarity(a.FormatString, b.FormatString) +
+		0.2*argumentSimilarity(a.ArgumentExprs, b.ArgumentExprs) +
+		0.2*locationSimilarity(a, b)
+}
+
+func callChanges(a *LogCall, b *LogCall) []CallChange {
+	changes := []CallChange{}
+	if a.FormatString != b.FormatString {
+		changes = append(changes, CallChangeFormat)
+	}
+	if !slices.Equal(a.ArgumentExprs, b.ArgumentExprs) {
+		changes = append(changes, CallChangeArguments)
+	}
+	if a.NormalizedLevel() != b.NormalizedLevel() {
+		changes = append(changes, CallChangeLevel)
+	}
+	if a.Method != b.Method {
+		changes = append(changes, CallChangeMethod)
+	}
+	if a.File != b.File {
+		changes = append(changes, CallChangeFile)
+	}
+	if a.Function != b.Function {
+		changes = append(changes, CallChangeFunction)
+	}
+	return changes
+}
+
+// DiffCorpusFiles matches the calls of two corpus files, and reports the calls
+// that were added, removed or changed from oldFile to newFile. Calls are first
+// matched by ID, then by identical format strings, and finally by the
+// similarity of their format strings, arguments and locations in the same file.
+func DiffCorpusFiles(oldFile *CorpusFile, newFile *CorpusFile) *CorpusDiff {
+	oldCalls, newCalls := oldFile.Calls, newFile.Calls
+	// Index of the matched call on the other side, or -1
+	oldMatch := make([]int, len(oldCalls))
+	newMatch := make([]int, len(newCalls))
+	for i := range oldMatch {
+		oldMatch[i] = -1
+	}
+	for j := range newMatch {
+		newMatch[j] = -1
+	}
+	match := func(i int, j int) {
+		oldMatch[i] = j
+		newMatch[j] = i
+	}
+
+	newByID := make(map[string]int)
+	for j, call := range newCalls {
+		if call.ID != "" {
+			newByID[call.ID] = j
+		}
+	}
+	for i, call := range oldCalls {
+		if j, ok := newByID[call.ID]; ok && call.ID != "" {
+			match(i, j)
+		}
+	}
+
+	newByFormat := make(map[string][]int)
+	for j, call := range newCalls {
+		if newMatch[j] < 0 && call.FormatString != "" {
+			newByFormat[call.FormatString] = append(newByFormat[call.FormatString], j)
+		}
+	}
+	for i := range oldCalls {
+		if oldMatch[i] >= 0 {
+			continue
+		}
+		best := -1
+		for _, j := range newByFormat[oldCalls[i].FormatString] {
+			if newMatch[j] < 0 && (best < 0 || locationSimilarity(&oldCalls[i], &newCalls[j]) > locationSimilarity(&oldCalls[i], &newCalls[best])) {
+				best = j
+			}
+		}
+		if best >= 0 {
+			match(i, best)
+		}
+	}
+
+	type candidate struct {
+		i, j       int
+		similarity float64
+	}
+	candidates := []candidate{}
+	for i := range oldCalls {
+		if oldMatch[i] >= 0 {
+			continue
+		}
+		for j := range newCalls {
+			if newMatch[j] >= 0 || oldCalls[i].File != newCalls[j].File {
+				continue
+			}
+			if similarity := callSimilarity(&oldCalls[i], &newCalls[j]); similarity >= minCallSimilarity {
+				candidates = append(candidates, candidate{i, j, similarity})
+			}
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+	for _, c := range candidates {
+		if oldMatch[c.i] < 0 && newMatch[c.j] < 0 {
+			match(c.i, c.j)
+		}
+	}
+
+	diff := &CorpusDiff{
+		OldProject: oldFile.Project,
+		OldVersion: oldFile.Version,
+		NewProject: newFile.Project,
+		NewVersion: newFile.Version,
+		Diffs:      []CallDiff{},
+	}
+	for i := range oldCalls {
+		if oldMatch[i] < 0 {
+			diff.Diffs = append(diff.Diffs, CallDiff{Kind: CallRemoved, Old: &oldCalls[i]})
+			continue
+		}
+		oldCall, newCall := &oldCalls[i], &newCalls[oldMatch[i]]
+		changes := callChanges(oldCall, newCall)
+		if len(changes) == 0 {
+			diff.Unchanged++
+			continue
+		}
+		diff.Diffs = append(diff.Diffs, CallDiff{
+			Kind:       CallChanged,
+			Old:        oldCall,
+			New:        newCall,
+			Changes:    changes,
+			Similarity: callSimilarity(oldCall, newCall),
+		})
+	}
+	for j := range newCalls {
+		if newMatch[j] < 0 {
+			diff.Diffs = append(diff.Diffs, CallDiff{Kind: CallAdded, New: &newCalls[j]})
+		}
+	}
+	// Ordered by the location in newFile, or in oldFile for removed calls
+	location := func(d CallDiff) *LogCall {
+		if d.New != nil {
+			return d.New
+		}
+		return d.Old
+	}
+	slices.SortStableFunc(diff.Diffs, func(a, b CallDiff) int {
+		la, lb := location(a), location(b)
+		return cmp.Or(cmp.Compare(la.File, lb.File), cmp.Compare(la.Line, lb.Line), cmp.Compare(la.Column, lb.Column))
+	})
+	return diff
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestStringSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"abc", "abc", 1},
+		{"abc", "", 0},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"héllo", "hello", 1 - 1.0/5},
+	}
+	for _, tt := range tests {
+		if got := stringSimilarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("stringSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// describeDiff summarizes a CallDiff as "<kind> <old file:line> -> <new file:line> <changes>".
+func describeDiff(d CallDiff) string {
+	location := func(call *LogCall) string {
+		if call == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%s:%d", call.File, call.Line)
+	}
+	return fmt.Sprintf("%s %s -> %s %v", d.Kind, location(d.Old), location(d.New), d.Changes)
+}
+
+func TestDiffCorpusFiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		old           []LogCall
+		new           []LogCall
+		wantUnchanged int
+		want          []string
+	}{
+		{
+			name:          "moved lines only",
+			old:           []LogCall{{ID: "a", File: "a.go", Line: 10, FormatString: "started"}},
+			new:           []LogCall{{ID: "a", File: "a.go", Line: 20, FormatString: "started"}},
+			wantUnchanged: 1,
+			want:          []string{},
+		},
+		{
+			name: "matched by ID first",
+			old:  []LogCall{{ID: "a", File: "a.go", Line: 10, FormatString: "connection lost"}},
+			new: []LogCall{
+				{ID: "b", File: "a.go", Line: 10, FormatString: "connection lost"},
+				{ID: "a", File: "b.go", Line: 3, FormatString: "connection dropped"},
+			},
+			want: []string{
+				"added - -> a.go:10 []",
+				"changed a.go:10 -> b.go:3 [format file]",
+			},
+		},
+		{
+			name: "matched by format, nearest location first",
+			old: []LogCall{
+				{ID: "a", File: "a.go", Line: 100, FormatString: "user %s logged in"},
+				{ID: "b", File: "a.go", Line: 10, FormatString: "user %s logged in"},
+			},
+			new: []LogCall{
+				{ID: "c", File: "a.go", Line: 12, FormatString: "user %s logged in"},
+				{ID: "d", File: "a.go", Line: 102, FormatString: "user %s logged in", Function: "login"},
+			},
+			wantUnchanged: 1,
+			want:          []string{"changed a.go:100 -> a.go:102 [function]"},
+		},
+		{
+			name: "matched by similarity in the same file",
+			old: []LogCall{
+				{ID: "a", File: "a.go", Line: 10, FormatString: "failed to open %s: %s", ArgumentExprs: []string{"path", "err"}},
+				{ID: "b", File: "a.go", Line: 30, FormatString: "starting"},
+			},
+			new: []LogCall{
+				{ID: "c", File: "a.go", Line: 11, FormatString: "failed to open file %s: %s", ArgumentExprs: []string{"path", "err"}},
+				{ID: "d", File: "a.go", Line: 31, FormatString: "shutting down server"},
+			},
+			want: []string{
+				"changed a.go:10 -> a.go:11 [format]",
+				"removed a.go:30 -> - []",
+				"added - -> a.go:31 []",
+			},
+		},
+		{
+			name: "not matched by similarity across files",
+			old:  []LogCall{{ID: "a", File: "a.go", Line: 10, FormatString: "failed to open %s"}},
+			new:  []LogCall{{ID: "b", File: "b.go", Line: 10, FormatString: "failed to open %q"}},
+			want: []string{
+				"removed a.go:10 -> - []",
+				"added - -> b.go:10 []",
+			},
+		},
+		{
+			name: "level and arguments",
+			old:  []LogCall{{ID: "a", File: "a.go", Line: 10, Method: "Info", FormatString: "retrying %d", ArgumentExprs: []string{"n"}}},
+			new:  []LogCall{{ID: "a", File: "a.go", Line: 10, Method: "Warn", FormatString: "retrying %d", ArgumentExprs: []string{"attempt"}}},
+			want: []string{"changed a.go:10 -> a.go:10 [arguments level method]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffCorpusFiles(&CorpusFile{Calls: tt.old}, &CorpusFile{Calls: tt.new})
+			got := []string{}
+			for _, d := range diff.Diffs {
+				got = append(got, describeDiff(d))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diffs = %q, want %q", got, tt.want)
+			}
+			if diff.Unchanged != tt.wantUnchanged {
+				t.Errorf("unchanged = %d, want %d", diff.Unchanged, tt.wantUnchanged)
+			}
+			if got, want := diff.Count(CallChanged)+diff.Count(CallRemoved)+diff.Unchanged, len(tt.old); got != want {
+				t.Errorf("changed + removed + unchanged = %d, want %d old calls", got, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"slices"
 	"strings"
 
 	sitter "github.com/smacker/go-tree-sitter"
@@ -17,6 +18,8 @@ type LanguageDef struct {
 	Suffixes       []string
 	Name           string
 	SitterLanguage *sitter.Language
+	// Node types of functions, methods and classes, to find the scope enclosing a log call
+	ScopeNodeTypes []string
 }
 
 var LanguageDefs = []LanguageDef{
@@ -24,36 +27,43 @@ var LanguageDefs = []LanguageDef{
 		Suffixes:       []string{".c"},
 		Name:           "C",
 		SitterLanguage: sitterC.GetLanguage(),
+		ScopeNodeTypes: []string{"function_definition"},
 	},
 	{
 		Suffixes:       []string{".cpp", ".cc", ".cxx", ".h", ".hpp"},
 		Name:           "Cpp",
 		SitterLanguage: sitterCpp.GetLanguage(),
+		ScopeNodeTypes: []string{"function_definition", "class_specifier", "struct_specifier", "namespace_definition"},
 	},
 	{
 		Suffixes:       []string{".java"},
 		Name:           "Java",
 		SitterLanguage: sitterJava.GetLanguage(),
+		ScopeNodeTypes: []string{"method_declaration", "constructor_declaration", "class_declaration", "interface_declaration", "enum_declaration"},
 	},
 	{
 		Suffixes:       []string{".py"},
 		Name:           "Python",
 		SitterLanguage: sitterPython.GetLanguage(),
+		ScopeNodeTypes: []string{"function_definition", "class_definition"},
 	},
 	{
 		Suffixes:       []string{".go"},
 		Name:           "Go",
 		SitterLanguage: sitterGolang.GetLanguage(),
+		ScopeNodeTypes: []string{"function_declaration", "method_declaration"},
 	},
 	{
 		Suffixes:       []string{".js", ".mjs", ".cjs", ".jsx"},
 		Name:           "Javascript",
 		SitterLanguage: sitterJavascript.GetLanguage(),
+		ScopeNodeTypes: []string{"function_declaration", "generator_function_declaration", "method_definition", "class_declaration"},
 	},
 	{
 		Suffixes:       []string{".ts", ".tsx"},
 		Name:           "Typescript",
 		SitterLanguage: sitterTypescript.GetLanguage(),
+		ScopeNodeTypes: []string{"function_declaration", "generator_function_declaration", "method_definition", "class_declaration", "abstract_class_declaration"},
 	},
 }
 
@@ -76,3 +86,38 @@ func GetLanguageDefByName(name string) *LanguageDef {
 	}
 	return nil
 }
+
+// scopeName returns the name of a function, method or class node, or "" if it
+// is anonymous.
+func scopeName(node *sitter.Node, source []byte) string {
+	if name := node.ChildByFieldName("name"); name != nil {
+		return name.Content(source)
+	}
+	// C and C++ functions are named by nested declarators, e.g.
+	// function_definition > pointer_declarator > function_declarator > identifier
+	declarator := node.ChildByFieldName("declarator")
+	for declarator != nil {
+		if inner := declarator.ChildByFieldName("declarator"); inner != nil {
+			declarator = inner
+			continue
+		}
+		return declarator.Content(source)
+	}
+	return ""
+}
+
+// EnclosingScope returns the names of the functions, methods and classes
+// enclosing node, joined by "." from the outermost one.
+func (def *LanguageDef) EnclosingScope(node *sitter.Node, source []byte) string {
+	names := []string{}
+	for parent := node.Parent(); parent != nil; parent = parent.Parent() {
+		if !slices.Contains(def.ScopeNodeTypes, parent.Type()) {
+			continue
+		}
+		if name := scopeName(parent, source); name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Reverse(names)
+	return strings.Join(names, ".")
+}
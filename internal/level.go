@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Log levels that levels of log calls and log lines are normalized to
+const (
+	LogLevelTrace = "trace"
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+	LogLevelFatal = "fatal"
+)
+
+var AllLogLevels = []string{LogLevelTrace, LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError, LogLevelFatal}
+
+// Keywords of each level, checked in order against the start of each word.
+// E.g. KERN_ERR, LOG_WARNING, logging.ERROR, Errorf and debug3 are all
+// recognized, but not interrupt or refine.
+var logLevelKeywords = []struct {
+	level    string
+	keywords []string
+}{
+	{LogLevelFatal, []string{"fatal", "crit", "emerg", "alert", "panic"}},
+	{LogLevelError, []string{"err", "severe"}},
+	{LogLevelWarn, []string{"warn"}},
+	{LogLevelInfo, []string{"info", "notice"}},
+	{LogLevelDebug, []string{"debug", "dbg"}},
+	{LogLevelTrace, []string{"trace", "verbose", "fine"}},
+}
+
+// levelWords splits a level name, constant or log method into lowercase
+// words, at non-letters and case changes, e.g. LogErrorf into log and errorf.
+func levelWords(s string) []string {
+	words := []string{}
+	runes := []rune(s)
+	start := -1
+	for i, r := range runes {
+		if !unicode.IsLetter(r) {
+			if start >= 0 {
+				words = append(words, strings.ToLower(string(runes[start:i])))
+				start = -1
+			}
+			continue
+		}
+		// A word starts at an upper case letter after a lower case one, or at the
+		// last upper case letter of a run followed by a lower case one, as in HTTPError
+		if start >= 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) ||
+			(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			words = append(words, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, strings.ToLower(string(runes[start:])))
+	}
+	return words
+}
+
+// NormalizeLogLevel maps a level name, constant or log method to one of
+// AllLogLevels, or returns "" if it doesn't name a level.
+func NormalizeLogLevel(s string) string {
+	words := levelWords(s)
+	for _, entry := range logLevelKeywords {
+		for _, keyword := range entry.keywords {
+			for _, word := range words {
+				if strings.HasPrefix(word, keyword) {
+					return entry.level
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// NormalizedLevel returns the level of the call, falling back to the level
+// implied by its method, e.g. Errorf.
+func (call *LogCall) NormalizedLevel() string {
+	if level := NormalizeLogLevel(call.Level); level != "" {
+		return level
+	}
+	return NormalizeLogLevel(call.Method)
+}
+
+// Level tokens found in the header of log lines, e.g. "[ERROR]", "level=warn",
+// "W0102" of glog or "E/" of logcat
+var lineLevelRe = regexp.MustCompile(`(?i)\b(trace|debug|info|notice|warn|warning|err|error|crit|critical|fatal|alert|emerg|panic|severe)\b|(?:^|\s)([TDIWEF])(?:\d{4} |/)`)
+
+var singleLetterLevels = map[string]string{
+	"T": LogLevelTrace, "D": LogLevelDebug, "I": LogLevelInfo, "W": LogLevelWarn, "E": LogLevelError, "F": LogLevelFatal,
+}
+
+// DetectLineLevel returns the normalized level announced in the header of a
+// log line, i.e. the text before the logged message, or "" if there is none.
+func DetectLineLevel(header string) string {
+	m := lineLevelRe.FindStringSubmatch(header)
+	if m == nil {
+		return ""
+	}
+	if m[1] != "" {
+		return NormalizeLogLevel(m[1])
+	}
+	return singleLetterLevels[m[2]]
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLevelWords(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{}},
+		{"error", []string{"error"}},
+		{"LogErrorf", []string{"log", "errorf"}},
+		{"KERN_ERR", []string{"kern", "err"}},
+		{"logging.WARNING", []string{"logging", "warning"}},
+		{"HTTPError", []string{"http", "error"}},
+		{"debug3", []string{"debug"}},
+		{"V(2).Info", []string{"v", "info"}},
+		{"Écrire", []string{"écrire"}},
+	}
+	for _, tt := range tests {
+		if got := levelWords(tt.s); !slices.Equal(got, tt.want) {
+			t.Errorf("levelWords(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLogLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", ""},
+		{"Errorf", LogLevelError},
+		{"KERN_ERR", LogLevelError},
+		{"LOG_WARNING", LogLevelWarn},
+		{"logging.ERROR", LogLevelError},
+		{"SEVERE", LogLevelError},
+		{"debug3", LogLevelDebug},
+		{"pr_notice", LogLevelInfo},
+		{"KERN_EMERG", LogLevelFatal},
+		{"log.Panicf", LogLevelFatal},
+		{"FINEST", LogLevelTrace},
+		{"Verbose", LogLevelTrace},
+		{"HTTPError", LogLevelError},
+		// Keywords only match at the start of a word
+		{"interrupt", ""},
+		{"refine", ""},
+		{"Printf", ""},
+		// Fatal is checked first
+		{"FatalError", LogLevelFatal},
+	}
+	for _, tt := range tests {
+		if got := NormalizeLogLevel(tt.s); got != tt.want {
+			t.Errorf("NormalizeLogLevel(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizedLevel(t *testing.T) {
+	tests := []struct {
+		level, method string
+		want          string
+	}{
+		{"", "Warnf", LogLevelWarn},
+		{"logging.DEBUG", "log", LogLevelDebug},
+		{"lvl", "Errorf", LogLevelError},
+		{"", "Printf", ""},
+	}
+	for _, tt := range tests {
+		call := &LogCall{Level: tt.level, Method: tt.method}
+		if got := call.NormalizedLevel(); got != tt.want {
+			t.Errorf("NormalizedLevel of level %q, method %q = %q, want %q", tt.level, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestDetectLineLevel(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"2024-10-16 12:00:00 [ERROR] ", LogLevelError},
+		{"ts=1 level=warn ", LogLevelWarn},
+		{"W1016 12:00:00.123456 1 a.go:1] ", LogLevelWarn},
+		{"E/ActivityManager: ", LogLevelError},
+		{"<3> CRITICAL ", LogLevelFatal},
+		{"12:00:00 ", ""},
+		{"errors=3 ", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectLineLevel(tt.header); got != tt.want {
+			t.Errorf("DetectLineLevel(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Variables of link templates, besides attr.<name> for the custom_attrs of the definition
+var linkTemplateVariables = []string{
+	"file", "line", "column", "end_line", "end_column", "root", "root_file",
+	"project", "version", "commit", "blame_commit", "method", "definition_id", "id", "function",
+}
+
+const linkTemplateAttrPrefix = "attr."
+
+// Filters of link templates, applied as {variable|filter}
+var linkTemplateFilters = map[string]func(string) string{
+	// Escapes each segment of a path, keeping the slashes
+	"urlpath": func(s string) string {
+		segments := strings.Split(s, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return strings.Join(segments, "/")
+	},
+	// Escapes a single path segment, including slashes
+	"urlsegment": url.PathEscape,
+	"urlquery":   url.QueryEscape,
+}
+
+type linkTemplatePart struct {
+	literal  string
+	variable string
+	filters  []func(string) string
+}
+
+// LinkTemplate is a parsed link_template. Placeholders are written as
+// {variable} or {variable|filter|...}, and literal braces as {{ and }}.
+type LinkTemplate struct {
+	parts []linkTemplatePart
+}
+
+// ParseLinkTemplate parses a link template of a definition with the given custom attrs.
+func ParseLinkTemplate(template string, attrs map[string]string) (*LinkTemplate, error) {
+	t := &LinkTemplate{}
+	literal := strings.Builder{}
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if (c == '{' || c == '}') && i+1 < len(template) && template[i+1] == c {
+			literal.WriteByte(c)
+			i++
+			continue
+		}
+		if c == '}' {
+			return nil, fmt.Errorf("unmatched '}' at offset %d of %q", i, template)
+		}
+		if c != '{' {
+			literal.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(template[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder at offset %d of %q", i, template)
+		}
+		fields := strings.Split(template[i+1:i+end], "|")
+		part := linkTemplatePart{literal: literal.String(), variable: strings.TrimSpace(fields[0])}
+		literal.Reset()
+		if attr, ok := strings.CutPrefix(part.variable, linkTemplateAttrPrefix); ok {
+			if _, ok := attrs[attr]; !ok {
+				return nil, fmt.Errorf("unknown custom attr %q in %q. Defined attrs: %q", attr, template, slices.Sorted(maps.Keys(attrs)))
+			}
+		} else if !slices.Contains(linkTemplateVariables, part.variable) {
+			return nil, fmt.Errorf("unknown variable {%s} in %q. Valid variables: %q and attr.<name>", part.variable, template, linkTemplateVariables)
+		}
+		for _, name := range fields[1:] {
+			filter, ok := linkTemplateFilters[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown filter %q in %q. Valid filters: %q", name, template, slices.Sorted(maps.Keys(linkTemplateFilters)))
+			}
+			part.filters = append(part.filters, filter)
+		}
+		t.parts = append(t.parts, part)
+		i += end
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, linkTemplatePart{literal: literal.String()})
+	}
+	return t, nil
+}
+
+// Expand substitutes the placeholders of the template with vars.
+func (t *LinkTemplate) Expand(vars map[string]string) string {
+	res := strings.Builder{}
+	for _, part := range t.parts {
+		res.WriteString(part.literal)
+		if part.variable == "" {
+			continue
+		}
+		value := vars[part.variable]
+		for _, filter := range part.filters {
+			value = filter(value)
+		}
+		res.WriteString(value)
+	}
+	return res.String()
+}
+
+// linkVariables returns the values of the link template variables for a call
+// of corpusFile found by def.
+func linkVariables(call *LogCall, def *LogCallDefinition, corpusFile *CorpusFile) map[string]string {
+	// The commit the corpus was built from, which {file} and {line} refer to
+	commit := corpusFile.Revision
+	if commit == "" {
+		commit = corpusFile.Provenance.Head
+	}
+	// The last commit that changed the call, if built with --blame
+	blameCommit := ""
+	if call.Blame != nil {
+		blameCommit = call.Blame.Commit
+	}
+	vars := map[string]string{
+		"file":          call.File,
+		"line":          strconv.Itoa(call.Line),
+		"column":        strconv.Itoa(call.Column),
+		"end_line":      strconv.Itoa(max(call.EndLine, call.Line)),
+		"end_column":    strconv.Itoa(call.EndColumn),
+		"root":          call.Root,
+		"root_file":     strings.TrimPrefix(call.File, call.Root+"/"),
+		"project":       call.Project,
+		"version":       corpusFile.Version,
+		"commit":        commit,
+		"blame_commit":  blameCommit,
+		"method":        call.Method,
+		"definition_id": call.DefinitionID,
+		"id":            call.ID,
+		"function":      call.Function,
+	}
+	for name, value := range def.CustomAttrs {
+		vars[linkTemplateAttrPrefix+name] = value
+	}
+	return vars
+}
+
+// Names of the link targets of a matched call besides the links of its definition
+const (
+	// The link_template of the definition
+	LinkTargetWeb = "web"
+	// The local file opened in ViewConfig.Editor
+	LinkTargetEditor = "editor"
+)
+
+// Links to a local file at a line and column, opened in each editor
+var editorLinks = map[string]func(path string, line int, column int) string{
+	"vscode": func(path string, line int, column int) string {
+		return (&url.URL{Scheme: "vscode", Host: "file", Path: path}).String() + fmt.Sprintf(":%d:%d", line, max(column, 1))
+	},
+	"idea": func(path string, line int, column int) string {
+		return fmt.Sprintf("idea://open?file=%s&line=%d&column=%d", url.QueryEscape(path), line, max(column, 1))
+	},
+	"file": func(path string, line int, column int) string {
+		return (&url.URL{Scheme: "file", Path: path}).String()
+	},
+}
+
+// EditorNames lists the editors ViewConfig.Editor may name.
+func EditorNames() []string {
+	return slices.Sorted(maps.Keys(editorLinks))
+}
+
+// localSourcePath returns the absolute path of a file of the repo checked out
+// at sourceRoot. A leading ~/ of sourceRoot is the home directory.
+func localSourcePath(sourceRoot string, file string) string {
+	if rest, ok := strings.CutPrefix(sourceRoot, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			sourceRoot = filepath.Join(home, rest)
+		}
+	}
+	path := filepath.Join(sourceRoot, filepath.FromSlash(file))
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+	return path
+}
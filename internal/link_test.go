@@ -0,0 +1,81 @@
+package internal
+
+import "testing"
+
+func TestParseLinkTemplate(t *testing.T) {
+	attrs := map[string]string{"team": "auth"}
+	vars := map[string]string{
+		"file":          "src/a b/c#d.go",
+		"line":          "42",
+		"commit":        "abc123",
+		"attr.team":     "auth",
+		"function":      "a/b c",
+		"definition_id": "def",
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"literal", "https://example.com/", "https://example.com/", false},
+		{"variables", "https://example.com/{commit}/{file}#L{line}", "https://example.com/abc123/src/a b/c#d.go#L42", false},
+		{"urlpath keeps slashes", "/{file|urlpath}", "/src/a%20b/c%23d.go", false},
+		{"urlsegment escapes slashes", "/{function|urlsegment}", "/a%2Fb%20c", false},
+		{"urlquery", "?q={function|urlquery}", "?q=a%2Fb+c", false},
+		{"chained filters", "{function|urlsegment|urlquery}", "a%252Fb%2520c", false},
+		{"spaces around names", "{ file | urlpath }", "src/a%20b/c%23d.go", false},
+		{"custom attr", "/teams/{attr.team}", "/teams/auth", false},
+		{"escaped braces", "{{{line}}}", "{42}", false},
+		{"unset variable expands empty", "{end_line}", "", false},
+		{"unknown variable", "{nope}", "", true},
+		{"unknown attr", "{attr.owner}", "", true},
+		{"unknown filter", "{file|upper}", "", true},
+		{"unterminated placeholder", "x{file", "", true},
+		{"unmatched closing brace", "x}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseLinkTemplate(tt.template, attrs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseLinkTemplate(%q) succeeded, want error", tt.template)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLinkTemplate(%q): %v", tt.template, err)
+			}
+			if got := tmpl.Expand(vars); got != tt.want {
+				t.Errorf("Expand(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkVariables(t *testing.T) {
+	call := &LogCall{File: "sub/pkg/a.go", Line: 3, Column: 5, Root: "sub", Project: "p", ID: "id-2",
+		Blame: &BlameInfo{Commit: "blamed"}}
+	def := &LogCallDefinition{ID: "def", CustomAttrs: map[string]string{"team": "auth"}}
+	tests := []struct {
+		name     string
+		revision string
+		head     string
+		want     map[string]string
+	}{
+		{"revision", "v1", "head", map[string]string{"commit": "v1", "blame_commit": "blamed", "root_file": "pkg/a.go", "end_line": "3", "attr.team": "auth"}},
+		{"head of the work tree", "", "head", map[string]string{"commit": "head"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corpusFile := &CorpusFile{Revision: tt.revision}
+			corpusFile.Provenance.Head = tt.head
+			vars := linkVariables(call, def, corpusFile)
+			for name, want := range tt.want {
+				if vars[name] != want {
+					t.Errorf("{%s} = %q, want %q", name, vars[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// LintIssue is why log lines of a call can't be reliably attributed to it.
+type LintIssue string
+
+const (
+	// The format string has fewer literal characters than min_match_chars
+	LintIssueFewLiteralChars LintIssue = "few_literal_chars"
+	// The format string has fewer literal word characters than min_match_word_chars
+	LintIssueFewWordChars LintIssue = "few_word_chars"
+	// Other call sites use the same format string
+	LintIssueDuplicate LintIssue = "duplicate"
+	// Other calls match its log lines at least as well
+	LintIssueShadowed LintIssue = "shadowed"
+	// Its own log lines are not attributed to it
+	LintIssueUnmatched LintIssue = "unmatched"
+)
+
+// LintResult lists the issues of a single log call.
+type LintResult struct {
+	Call LogCall `json:"call"`
+	// A log line the call could print, used to probe the other patterns
+	Sample string      `json:"sample"`
+	Issues []LintIssue `json:"issues"`
+	// Other call sites with the same format string
+	Duplicates []LogCall `json:"duplicates,omitempty"`
+	// Calls with other format strings matching Sample at least as well
+	ShadowedBy []LogCall `json:"shadowed_by,omitempty"`
+	// Higher is worse
+	Score int `json:"score"`
+}
+
+func countLiteralChars(format string) (int, int) {
+	literal := printfSpecRe.ReplaceAllString(format, "")
+	wordChars := 0
+	for _, r := range literal {
+		if syntax.IsWordChar(r) {
+			wordChars++
+		}
+	}
+	return len(literal), wordChars
+}
+
+// LintProject checks every call of a project, at the version selected by the
+// viewer, against the patterns compiled by the viewer. Calls without issues
+// are omitted, and the rest is ordered by descending score.
+func LintProject(v *Viewer, project string) ([]LintResult, error) {
+	version, ok := v.DefaultVersions[project]
+	if !ok {
+		return nil, fmt.Errorf("project %s is not loaded by the viewer", project)
+	}
+	scratch, err := v.AllocScratch()
+	if err != nil {
+		return nil, fmt.Errorf("error allocating hyperscan scratch: %w", err)
+	}
+	defer scratch.Free()
+
+	calls := v.Corpus[project][version].Calls
+	formatStringRefs := make(map[string][]int)
+	for i, call := range calls {
+		formatStringRefs[call.FormatString] = append(formatStringRefs[call.FormatString], i)
+	}
+
+	results := []LintResult{}
+	for i, call := range calls {
+		result := LintResult{
+			Call:   call,
+			Sample: SamplePrintfFormat(call.FormatString),
+		}
+		literalChars, wordChars := countLiteralChars(call.FormatString)
+		if literalChars < v.Config.MinMatchChars {
+			result.Issues = append(result.Issues, LintIssueFewLiteralChars)
+			result.Score += 5
+		}
+		if wordChars < v.Config.MinMatchWordChars {
+			result.Issues = append(result.Issues, LintIssueFewWordChars)
+			result.Score += 5
+		}
+		for _, j := range formatStringRefs[call.FormatString] {
+			if j != i {
+				result.Duplicates = append(result.Duplicates, calls[j])
+			}
+		}
+		if len(result.Duplicates) > 0 {
+			result.Issues = append(result.Issues, LintIssueDuplicate)
+			result.Score += len(result.Duplicates)
+		}
+
+		candidates, err := v.FindCandidates(result.Sample, scratch, v.DefaultVersions)
+		if err != nil {
+			return nil, err
+		}
+		self := LogCallRef{Project: project, Version: version, CallIndex: i}
+		selfIdx := slices.IndexFunc(candidates, func(c Candidate) bool { return c.LcRef == self })
+		if selfIdx < 0 || !v.IsConfident(candidates[selfIdx], len(result.Sample)) {
+			result.Issues = append(result.Issues, LintIssueUnmatched)
+			result.Score += 10
+		} else {
+			for _, c := range candidates {
+				other := v.getLogCallFromRef(c.LcRef)
+				if c.LcRef == self || other.FormatString == call.FormatString || candidates[selfIdx].BetterThan(c) {
+					continue
+				}
+				result.ShadowedBy = append(result.ShadowedBy, *other)
+			}
+			if len(result.ShadowedBy) > 0 {
+				result.Issues = append(result.Issues, LintIssueShadowed)
+				result.Score += 3 * len(result.ShadowedBy)
+			}
+		}
+		if len(result.Issues) > 0 {
+			results = append(results, result)
+		}
+	}
+	slices.SortStableFunc(results, func(a, b LintResult) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		if c := strings.Compare(a.Call.File, b.Call.File); c != 0 {
+			return c
+		}
+		return a.Call.Line - b.Call.Line
+	})
+	return results, nil
+}
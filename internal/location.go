@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SourceLocationFormat extracts the source location that a logging library
+// prints in each log line, captured by the named groups "file" and "line".
+type SourceLocationFormat struct {
+	Name        string
+	Description string
+	Regex       *regexp.Regexp
+	// The location is only looked for in the prefix and suffix around the
+	// logged message, since messages may mention source files themselves
+	OutsideMessage bool
+}
+
+// SourceLocationPresets are the source locations printed by known logging libraries.
+var SourceLocationPresets = []*SourceLocationFormat{
+	{
+		Name:        "glog",
+		Description: "glog and klog: I1016 12:00:00.123456    1234 file.go:42] ",
+		Regex:       regexp.MustCompile(`^[IWEF]\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ (?P<file>[^:\]\s]+):(?P<line>\d+)\] `),
+	},
+	{
+		Name:           "log4j",
+		Description:    "log4j and logback %F:%L or %file:%line in the line prefix or envelope: App.java:42",
+		Regex:          regexp.MustCompile(`(?P<file>[\w./$-]+\.(?:java|kt|scala|groovy)):(?P<line>\d+)\b`),
+		OutsideMessage: true,
+	},
+	{
+		Name:           "python",
+		Description:    "Python logging %(filename)s:%(lineno)d or %(pathname)s:%(lineno)d in the line prefix or envelope: app.py:42",
+		Regex:          regexp.MustCompile(`(?P<file>[\w./-]+\.py):(?P<line>\d+)\b`),
+		OutsideMessage: true,
+	},
+	{
+		Name:        "go",
+		Description: "Go log with Lshortfile or Llongfile: 2024/10/16 12:00:00 file.go:42: ",
+		Regex:       regexp.MustCompile(`^(?:\d{4}/\d{2}/\d{2} )?(?:\d{2}:\d{2}:\d{2}(?:\.\d+)? )?(?P<file>[^\s:]+\.go):(?P<line>\d+): `),
+	},
+	{
+		Name:        "zap",
+		Description: `zap caller, in JSON or console encoding: "caller":"pkg/file.go:42"`,
+		Regex:       regexp.MustCompile(`"caller":\s*"(?P<file>[^"]+):(?P<line>\d+)"|\t(?P<file>[^\s:]+\.go):(?P<line>\d+)\t`),
+	},
+}
+
+// Names of the groups of a source location regex
+const (
+	sourceLocationFileGroup = "file"
+	sourceLocationLineGroup = "line"
+)
+
+// SourceLocationNames lists the presets ViewConfig.SourceLocation may name.
+func SourceLocationNames() []string {
+	names := []string{}
+	for _, preset := range SourceLocationPresets {
+		names = append(names, preset.Name)
+	}
+	return names
+}
+
+// CompileSourceLocation returns the preset of the given name, or else compiles
+// a regex with the named groups "file" and "line".
+func CompileSourceLocation(nameOrRegex string) (*SourceLocationFormat, error) {
+	for _, preset := range SourceLocationPresets {
+		if preset.Name == nameOrRegex {
+			return preset, nil
+		}
+	}
+	re, err := regexp.Compile(nameOrRegex)
+	if err != nil {
+		return nil, fmt.Errorf("source_location %q is neither a preset (%s) nor a valid regex: %w", nameOrRegex, strings.Join(SourceLocationNames(), ", "), err)
+	}
+	if re.SubexpIndex(sourceLocationFileGroup) < 0 || re.SubexpIndex(sourceLocationLineGroup) < 0 {
+		return nil, fmt.Errorf("source_location %q must contain the named groups %q and %q", nameOrRegex, sourceLocationFileGroup, sourceLocationLineGroup)
+	}
+	return &SourceLocationFormat{Name: "custom", Regex: re}, nil
+}
+
+// Extract returns the source location printed in line. ok is false if line
+// has none.
+func (f *SourceLocationFormat) Extract(line string) (file string, lineNo int, ok bool) {
+	m := f.Regex.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, false
+	}
+	// Alternatives of a regex may capture the same group names
+	lineStr := ""
+	for i, name := range f.Regex.SubexpNames() {
+		if name == sourceLocationFileGroup && file == "" {
+			file = m[i]
+		} else if name == sourceLocationLineGroup && lineStr == "" {
+			lineStr = m[i]
+		}
+	}
+	return parseSourceLocation(file, lineStr)
+}
+
+func parseSourceLocation(file string, lineStr string) (string, int, bool) {
+	lineNo, err := strconv.Atoi(lineStr)
+	if file == "" || err != nil {
+		return "", 0, false
+	}
+	return file, lineNo, true
+}
+
+// sourcePathMatches reports whether a path printed by a logging library names
+// the file of a call, a path relative to the repo root. Basenames and paths
+// relative to a subdirectory match the calls whose file ends with them, and
+// absolute paths of the build machine match if they end with the file.
+func sourcePathMatches(callFile string, logged string) bool {
+	logged = strings.TrimPrefix(filepath.ToSlash(logged), "./")
+	return callFile == logged || strings.HasSuffix(callFile, "/"+logged) || strings.HasSuffix(logged, "/"+callFile)
+}
+
+// spansSourceLocation reports whether call was logged at file:line. Calls
+// spanning several lines may report any of them.
+func (call *LogCall) spansSourceLocation(file string, line int) bool {
+	return sourcePathMatches(call.File, file) && call.Line <= line && line <= max(call.EndLine, call.Line)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import "testing"
+
+func TestSourceLocationPresets(t *testing.T) {
+	tests := []struct {
+		preset   string
+		line     string
+		wantFile string
+		wantLine int
+		wantOK   bool
+	}{
+		{"glog", "I1016 12:00:00.123456    1234 server.go:42] listening", "server.go", 42, true},
+		{"glog", "listening on server.go:42] ", "", 0, false},
+		{"log4j", "12:00:00 INFO App.java:42 - started", "App.java", 42, true},
+		{"log4j", "12:00:00 INFO [main] com/example/Db.kt:7 slow", "com/example/Db.kt", 7, true},
+		{"python", "12:00:00 WARNING app/db.py:120 connection lost", "app/db.py", 120, true},
+		{"python", "connection lost", "", 0, false},
+		{"go", "2024/10/16 12:00:00 main.go:42: started", "main.go", 42, true},
+		{"go", "2024/10/16 12:00:00.123456 /src/pkg/main.go:42: started", "/src/pkg/main.go", 42, true},
+		{"go", "started main.go:42: ", "", 0, false},
+		// Both alternatives of the zap preset capture file and line
+		{"zap", `{"level":"info","caller":"server/http.go:88","msg":"started"}`, "server/http.go", 88, true},
+		{"zap", "2024-10-16T12:00:00.000Z\tINFO\tserver/http.go:88\tstarted", "server/http.go", 88, true},
+		{"zap", `{"level":"info","msg":"started"}`, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			format, err := CompileSourceLocation(tt.preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, line, ok := format.Extract(tt.line)
+			if file != tt.wantFile || line != tt.wantLine || ok != tt.wantOK {
+				t.Errorf("Extract(%q) = %q, %d, %v, want %q, %d, %v", tt.line, file, line, ok, tt.wantFile, tt.wantLine, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCompileSourceLocation(t *testing.T) {
+	tests := []struct {
+		nameOrRegex string
+		wantName    string
+		wantErr     bool
+	}{
+		{"zap", "zap", false},
+		{`\((?P<file>[^:]+):(?P<line>\d+)\)`, "custom", false},
+		{`\((?P<file>[^:]+):(\d+)\)`, "", true},
+		{`(?P<file>[^:]+`, "", true},
+	}
+	for _, tt := range tests {
+		format, err := CompileSourceLocation(tt.nameOrRegex)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("CompileSourceLocation(%q) succeeded, want error", tt.nameOrRegex)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CompileSourceLocation(%q): %v", tt.nameOrRegex, err)
+		} else if format.Name != tt.wantName {
+			t.Errorf("CompileSourceLocation(%q) = %q, want %q", tt.nameOrRegex, format.Name, tt.wantName)
+		}
+	}
+}
+
+func TestExtractSourceLocation(t *testing.T) {
+	python, err := CompileSourceLocation("python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                 string
+		format               *SourceLocationFormat
+		line, prefix, suffix string
+		fields               map[string]string
+		wantFile             string
+		wantLine             int
+		wantOK               bool
+	}{
+		{"prefix", python, "12:00 db.py:12 failed to load config.py:3", "12:00 db.py:12 ", "", nil, "db.py", 12, true},
+		{"suffix", python, "failed to load config.py:3 (db.py:12)", "", " (db.py:12)", nil, "db.py", 12, true},
+		// A file mentioned in the message doesn't pin the call
+		{"message only", python, "failed to load config.py:3", "", "", nil, "", 0, false},
+		{"envelope fields first", python, "12:00 db.py:12 x", "12:00 db.py:12 ", "",
+			map[string]string{"file": "main.py", "line": "5"}, "main.py", 5, true},
+		{"invalid envelope fields", python, "12:00 db.py:12 x", "12:00 db.py:12 ", "",
+			map[string]string{"file": "main.py", "line": "?"}, "db.py", 12, true},
+		{"no format", nil, "db.py:12", "db.py:12", "", nil, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Viewer{sourceLocation: tt.format}
+			file, line, ok := v.extractSourceLocation(tt.line, tt.prefix, tt.suffix, tt.fields)
+			if file != tt.wantFile || line != tt.wantLine || ok != tt.wantOK {
+				t.Errorf("extractSourceLocation(%q) = %q, %d, %v, want %q, %d, %v", tt.line, file, line, ok, tt.wantFile, tt.wantLine, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSpansSourceLocation(t *testing.T) {
+	call := &LogCall{File: "src/server/http.go", Line: 10, EndLine: 12}
+	tests := []struct {
+		file string
+		line int
+		want bool
+	}{
+		{"src/server/http.go", 10, true},
+		{"./src/server/http.go", 12, true},
+		{"http.go", 11, true},
+		{"server/http.go", 10, true},
+		{"/build/repo/src/server/http.go", 10, true},
+		{"rver/http.go", 10, false},
+		{"http.go", 13, false},
+		{"http.go", 9, false},
+		{"client/http.go", 10, false},
+	}
+	for _, tt := range tests {
+		if got := call.spansSourceLocation(tt.file, tt.line); got != tt.want {
+			t.Errorf("spansSourceLocation(%q, %d) = %v, want %v", tt.file, tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package internal
+
+import (
+	"os"
+
+	"github.com/phuslu/log"
+)
+
+// lockFile is a no-op on platforms without flock. Concurrent corpus builds
+// may then lose each other's manifest updates.
+func lockFile(f *os.File, exclusive bool) error {
+	log.Debug().Msgf("Advisory locks are not supported on this platform. Not locking %s", f.Name())
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on f, waiting for other holders to release it.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			if err != nil {
+				return fmt.Errorf("error locking %s: %w", f.Name(), err)
+			}
+			return nil
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/phuslu/log"
+)
+
+// ValidateProjectName rejects project names that can't be used as part of a
+// corpus file name, e.g. ones read from a bundle built on another machine.
+// '@' separates the version in file names and in {project}@{version}.
+func ValidateProjectName(project string) error {
+	if project == "" || strings.ContainsAny(project, `/\@`) || strings.Contains(project, "..") || filepath.Base(project) != project {
+		return fmt.Errorf("invalid project name %q", project)
+	}
+	return nil
+}
+
+// corpusFilePath returns the path of a corpus file in CorpusDir, after
+// checking that its project name doesn't make it escape CorpusDir.
+func corpusFilePath(c *CorpusFile) (string, error) {
+	if err := ValidateProjectName(c.Project); err != nil {
+		return "", err
+	}
+	filePath := c.GetPath()
+	if filepath.Dir(filePath) != filepath.Clean(CorpusDir) {
+		return "", fmt.Errorf("corpus file %q of project %s is outside of %s", filePath, c.Project, CorpusDir)
+	}
+	return filePath, nil
+}
+
+// errDiscardManifest is returned by the update function of updateManifest to
+// leave the manifest and the corpus files untouched.
+var errDiscardManifest = errors.New("manifest update discarded")
+
+// updateManifest calls update with the manifest under the exclusive lock of
+// CorpusDir, and saves the manifest afterwards unless update fails. Corpus files
+// staged by update replace the live ones only if update succeeds, right before
+// the manifest is saved. Corpus files dropped by update are only deleted once
+// the manifest no longer refers to them.
+func updateManifest(update func(m *CorpusManifest) error) error {
+	unlock, err := lockCorpusDir(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	manifest, err := readManifest()
+	if err != nil {
+		return err
+	}
+	defer manifest.discardStagedFiles()
+	if err := update(manifest); err != nil {
+		return err
+	}
+	if err := manifest.commitStagedFiles(); err != nil {
+		return err
+	}
+	if err := manifest.save(); err != nil {
+		return err
+	}
+	for _, filePath := range manifest.obsoleteFiles {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Warn().Msgf("error removing corpus file %q: %v", filePath, err)
+		}
+	}
+	return nil
+}
+
+// removeEntries drops the manifest entries matched by remove, and schedules
+// their corpus files for deletion. It returns the removed entries.
+func (m *CorpusManifest) removeEntries(remove func(entry ManifestEntry) bool) []ManifestEntry {
+	removed := []ManifestEntry{}
+	kept := []ManifestEntry{}
+	for _, entry := range m.Entries {
+		if !remove(entry) {
+			kept = append(kept, entry)
+			continue
+		}
+		m.obsoleteFiles = append(m.obsoleteFiles, entry.Path())
+		removed = append(removed, entry)
+	}
+	m.Entries = kept
+	return removed
+}
+
+// RemoveProject deletes a version of a project from the corpus, or all of its
+// versions if allVersions is set.
+func RemoveProject(project string, version string, allVersions bool) ([]ManifestEntry, error) {
+	var removed []ManifestEntry
+	err := updateManifest(func(m *CorpusManifest) error {
+		removed = m.removeEntries(func(entry ManifestEntry) bool {
+			return entry.Project == project && (allVersions || entry.Version == version)
+		})
+		if len(removed) == 0 {
+			return fmt.Errorf("no corpus file found for project: %s, version: %q", project, version)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// RenameProject renames all versions of a project.
+func RenameProject(oldName string, newName string) (int, error) {
+	if err := ValidateProjectName(newName); err != nil {
+		return 0, err
+	}
+	renamed := 0
+	err := updateManifest(func(m *CorpusManifest) error {
+		if slices.Contains(m.Projects(), newName) {
+			return fmt.Errorf("project %s already exists", newName)
+		}
+		for i, entry := range m.Entries {
+			if entry.Project != oldName {
+				continue
+			}
+			oldPath := entry.Path()
+			corpusFile, err := readCorpusFile(oldPath, entry.Checksum)
+			if err != nil {
+				return err
+			}
+			corpusFile.Project = newName
+			for j := range corpusFile.Calls {
+				corpusFile.Calls[j].Project = newName
+			}
+			if corpusFile.ContentHash, err = corpusFile.ComputeContentHash(); err != nil {
+				return fmt.Errorf("error hashing corpus file %q: %w", oldPath, err)
+			}
+			newEntry, err := m.stageCorpusFile(corpusFile)
+			if err != nil {
+				return err
+			}
+			m.obsoleteFiles = append(m.obsoleteFiles, oldPath)
+			m.Entries[i] = newEntry
+			renamed++
+		}
+		if renamed == 0 {
+			return fmt.Errorf("project %s not found", oldName)
+		}
+		return nil
+	})
+	return renamed, err
+}
+
+// PruneOptions selects the stale corpus files removed by PruneCorpus. The
+// latest build of each project is always kept.
+type PruneOptions struct {
+	// Number of most recent builds to keep per project. All are kept if <= 0
+	Keep int
+	// Remove builds older than this. Disabled if 0
+	OlderThan time.Duration
+	// Only report what would be removed
+	DryRun bool
+}
+
+// PruneCorpus removes stale builds of all projects, as well as manifest entries
+// whose corpus file is missing. It returns the removed entries.
+func PruneCorpus(opts PruneOptions) ([]ManifestEntry, error) {
+	var removed []ManifestEntry
+	err := updateManifest(func(m *CorpusManifest) error {
+		stale := make(map[string]bool)
+		// Ordered by project and build time, newest last
+		m.sortEntries()
+		newer := make(map[string]int)
+		for i := len(m.Entries) - 1; i >= 0; i-- {
+			entry := m.Entries[i]
+			if _, err := os.Stat(entry.Path()); os.IsNotExist(err) {
+				log.Warn().Msgf("Corpus file %s of project %s is missing", entry.File, entry.Project)
+				stale[entry.File] = true
+				continue
+			}
+			// Number of newer builds of the project
+			rank := newer[entry.Project]
+			newer[entry.Project]++
+			if rank > 0 && opts.Keep > 0 && rank >= opts.Keep {
+				stale[entry.File] = true
+			} else if rank > 0 && opts.OlderThan > 0 && time.Since(entry.BuiltAt) > opts.OlderThan {
+				stale[entry.File] = true
+			}
+		}
+		removed = m.removeEntries(func(entry ManifestEntry) bool {
+			return stale[entry.File]
+		})
+		if opts.DryRun {
+			return errDiscardManifest
+		}
+		return nil
+	})
+	if errors.Is(err, errDiscardManifest) {
+		err = nil
+	}
+	return removed, err
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestValidateProjectName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"openssh", false},
+		{"my-app.v2", false},
+		{"", true},
+		{"..", true},
+		{"a/b", true},
+		{`a\b`, true},
+		{"app@v1", true},
+		{"a..b", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateProjectName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateProjectName(%q) = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// saveTestBuilds saves builds of project that are the given ages old, as
+// versions named after their ages.
+func saveTestBuilds(t *testing.T, project string, ages ...time.Duration) {
+	t.Helper()
+	for _, age := range ages {
+		if err := testCorpusFile(project, age.String(), time.Now().Add(-age), "started").Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// manifestVersions lists the project@version of all entries of the manifest.
+func manifestVersions(t *testing.T) []string {
+	t.Helper()
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []string{}
+	for _, entry := range manifest.Entries {
+		versions = append(versions, entry.Project+"@"+entry.Version)
+	}
+	return versions
+}
+
+func TestPruneCorpus(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name        string
+		opts        PruneOptions
+		wantRemoved int
+		want        []string
+	}{
+		{"nothing to prune", PruneOptions{}, 0,
+			[]string{"app@720h0m0s", "app@240h0m0s", "app@24h0m0s", "lib@480h0m0s"}},
+		{"keep", PruneOptions{Keep: 2}, 1,
+			[]string{"app@240h0m0s", "app@24h0m0s", "lib@480h0m0s"}},
+		// The latest build of lib is kept although it is older
+		{"older than", PruneOptions{OlderThan: 5 * day}, 2,
+			[]string{"app@24h0m0s", "lib@480h0m0s"}},
+		{"keep and older than", PruneOptions{Keep: 1, OlderThan: 15 * day}, 2,
+			[]string{"app@24h0m0s", "lib@480h0m0s"}},
+		{"dry run", PruneOptions{Keep: 1, DryRun: true}, 2,
+			[]string{"app@720h0m0s", "app@240h0m0s", "app@24h0m0s", "lib@480h0m0s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCorpusDir(t)
+			saveTestBuilds(t, "app", 30*day, 10*day, day)
+			saveTestBuilds(t, "lib", 20*day)
+			removed, err := PruneCorpus(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(removed) != tt.wantRemoved {
+				t.Errorf("removed %d entries, want %d", len(removed), tt.wantRemoved)
+			}
+			if got := manifestVersions(t); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("manifest = %v, want %v", got, tt.want)
+			}
+			for _, entry := range removed {
+				_, err := os.Stat(entry.Path())
+				if exists := err == nil; exists != tt.opts.DryRun {
+					t.Errorf("corpus file %s exists: %v, want %v", entry.File, exists, tt.opts.DryRun)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneCorpusMissingFiles(t *testing.T) {
+	useCorpusDir(t)
+	saveTestBuilds(t, "app", 2*time.Hour, time.Hour)
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entry of the latest build is removed as well if its file is missing
+	if err := os.Remove(manifest.Entries[1].Path()); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := PruneCorpus(PruneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Version != "1h0m0s" {
+		t.Errorf("removed %+v, want app@1h0m0s", removed)
+	}
+	if got, want := fmt.Sprint(manifestVersions(t)), "[app@2h0m0s]"; got != want {
+		t.Errorf("manifest = %v, want %v", got, want)
+	}
+}
+
+func TestRenameProject(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldName     string
+		newName     string
+		wantRenamed int
+		wantErr     bool
+		want        []string
+	}{
+		{"all versions", "app", "server", 2, false, []string{"lib@1h0m0s", "server@2h0m0s", "server@1h0m0s"}},
+		{"existing project", "app", "lib", 0, true, []string{"app@2h0m0s", "app@1h0m0s", "lib@1h0m0s"}},
+		{"same name", "app", "app", 0, true, []string{"app@2h0m0s", "app@1h0m0s", "lib@1h0m0s"}},
+		{"missing project", "db", "database", 0, true, []string{"app@2h0m0s", "app@1h0m0s", "lib@1h0m0s"}},
+		{"invalid name", "app", "../app", 0, true, []string{"app@2h0m0s", "app@1h0m0s", "lib@1h0m0s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCorpusDir(t)
+			saveTestBuilds(t, "app", 2*time.Hour, time.Hour)
+			saveTestBuilds(t, "lib", time.Hour)
+			renamed, err := RenameProject(tt.oldName, tt.newName)
+			if (err != nil) != tt.wantErr || renamed != tt.wantRenamed {
+				t.Errorf("RenameProject(%q, %q) = %d, %v, want %d, error: %v", tt.oldName, tt.newName, renamed, err, tt.wantRenamed, tt.wantErr)
+			}
+			if got := manifestVersions(t); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("manifest = %v, want %v", got, tt.want)
+			}
+			corpus, err := ReadCorpus(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for project, versions := range corpus {
+				for _, file := range versions {
+					for _, call := range file.Calls {
+						if call.Project != project {
+							t.Errorf("call of project %s belongs to %s", project, call.Project)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveProject(t *testing.T) {
+	useCorpusDir(t)
+	saveTestBuilds(t, "app", 2*time.Hour, time.Hour)
+	if _, err := RemoveProject("app", "3h0m0s", false); err == nil {
+		t.Errorf("RemoveProject of a missing version succeeded, want error")
+	}
+	removed, err := RemoveProject("app", "2h0m0s", false)
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("RemoveProject = %v, %v, want app@2h0m0s", removed, err)
+	}
+	if _, err := os.Stat(removed[0].Path()); !os.IsNotExist(err) {
+		t.Errorf("corpus file of the removed version still exists: %v", err)
+	}
+	if removed, err := RemoveProject("app", "", true); err != nil || len(removed) != 1 {
+		t.Errorf("RemoveProject of all versions = %v, %v, want app@1h0m0s", removed, err)
+	}
+	if got := manifestVersions(t); len(got) != 0 {
+		t.Errorf("manifest = %v, want no entries", got)
+	}
+}
@@ -21,8 +21,64 @@ type ParsedFormatter struct {
 	HyperScanRegex string
 }
 
+var printfSpecRe = regexp.MustCompile(`%([#+0\- ]*)(\d*)(?:\.(\d+))?[hlLjzt]*([diuoxXfFeEgGaAcsp])`)
+
+// SamplePrintfFormat renders a printf-like format string with a
+// representative value for each directive, as it could appear in a log line.
+func SamplePrintfFormat(format string) string {
+	return printfSpecRe.ReplaceAllStringFunc(format, func(directive string) string {
+		m := printfSpecRe.FindStringSubmatch(directive)
+		flags, widthStr, spec := m[1], m[2], m[4]
+		value := ""
+		switch spec {
+		case "d", "i", "u":
+			value = "42"
+		case "o":
+			value = "52"
+		case "x":
+			value = "2a"
+		case "X":
+			value = "2A"
+		case "f", "F":
+			precision := 6
+			if m[3] != "" {
+				precision, _ = strconv.Atoi(m[3])
+			}
+			value = strconv.FormatFloat(1.5, 'f', precision, 64)
+		case "e", "E":
+			precision := 6
+			if m[3] != "" {
+				precision, _ = strconv.Atoi(m[3])
+			}
+			value = strconv.FormatFloat(1.5, 'e', precision, 64)
+		case "g", "G":
+			value = "1.5"
+		case "a", "A":
+			value = "0x1.800000p+0"
+		case "c":
+			value = "c"
+		case "p":
+			value = "0x7ffc1234"
+		default:
+			value = "value"
+		}
+		if precision, err := strconv.Atoi(m[3]); err == nil && spec == "s" && precision < len(value) {
+			value = value[:precision]
+		}
+		if width, err := strconv.Atoi(widthStr); err == nil && width > len(value) {
+			padding := strings.Repeat(" ", width-len(value))
+			if strings.Contains(flags, "-") {
+				value += padding
+			} else {
+				value = padding + value
+			}
+		}
+		return value
+	})
+}
+
 func ParsePrintfFormat(format string, topLevelGroupName string) (ParsedFormatter, error) {
-	specRe := regexp.MustCompile(`%([#+0\- ]*)(\d*)(?:\.(\d+))?[hlLjzt]*([diuoxXfFeEgGaAcsp])`)
+	specRe := printfSpecRe
 
 	matches := specRe.FindAllStringSubmatchIndex(format, -1)
 	if len(matches) == 0 {
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LinePrefix recognizes the prefix that a logging library or log collector
+// puts in front of each logged message.
+type LinePrefix struct {
+	Name        string
+	Description string
+	// Matches the prefix at the start of a line
+	Regex *regexp.Regexp
+}
+
+// Split splits line into its prefix and the logged message. The prefix is
+// empty if the line doesn't start with one.
+func (p *LinePrefix) Split(line string) (string, string) {
+	loc := p.Regex.FindStringIndex(line)
+	if loc == nil {
+		return "", line
+	}
+	return line[:loc[1]], line[loc[1]:]
+}
+
+// Names of ViewConfig.LinePrefix besides the presets
+const (
+	// Detect the preset from the first lines, see DetectLinePrefix
+	LinePrefixAuto = "auto"
+	// Use StartPos and StartCharPos
+	LinePrefixNone = "none"
+)
+
+// LinePrefixPresets are the known line prefixes. More specific presets come
+// first, as DetectLinePrefix prefers them on ties.
+var LinePrefixPresets = []*LinePrefix{
+	{
+		Name:        "syslog5424",
+		Description: "RFC 5424 syslog: <34>1 2024-10-16T12:00:00Z host app 123 ID47 [sd] ",
+		Regex:       regexp.MustCompile(`^(?:<\d{1,3}>)?1 \S+ \S+ \S+ \S+ \S+ (?:-|(?:\[(?:[^\]"]|"(?:[^"\\]|\\.)*")*\])+) ?`),
+	},
+	{
+		Name:        "syslog",
+		Description: "RFC 3164 syslog and journalctl: Oct 16 12:00:00 host sshd[123]: ",
+		Regex:       regexp.MustCompile(`^(?:<\d{1,3}>)?[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2} \S+ [^\s:\[]+(?:\[\d+\])?: `),
+	},
+	{
+		Name:        "dmesg",
+		Description: "Kernel ring buffer: [   12.345678] ",
+		Regex:       regexp.MustCompile(`^\[\s*\d+\.\d+\] `),
+	},
+	{
+		Name:        "glog",
+		Description: "glog and klog: I1016 12:00:00.123456    1234 file.go:42] ",
+		Regex:       regexp.MustCompile(`^[IWEF]\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ [^\]\s]+\] `),
+	},
+	{
+		Name:        "logcat",
+		Description: "Android logcat threadtime: 10-16 12:00:00.123  1234  5678 I Tag: ",
+		Regex:       regexp.MustCompile(`^\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}\s+\d+\s+\d+ [VDIWEFA] [^:]*: `),
+	},
+	{
+		Name:        "log4j",
+		Description: "log4j and logback patterns like %d [%t] %-5p %c - %m: 2024-10-16 12:00:00,123 [main] INFO  com.example.App - ",
+		Regex:       regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}[,.]\d{3} (?:\[[^\]]*\] )?(?:TRACE|DEBUG|INFO|WARN|ERROR|FATAL)\s+\S+ (?:- )?`),
+	},
+	{
+		Name:        "python",
+		Description: "Python logging default format: WARNING:root:",
+		Regex:       regexp.MustCompile(`^(?:DEBUG|INFO|WARNING|ERROR|CRITICAL):[^:\s]*:`),
+	},
+	{
+		Name:        "iso8601",
+		Description: "ISO 8601 timestamp with an optional level: 2024-10-16T12:00:00.123Z [INFO] ",
+		Regex:       regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?\s+(?:\[?(?:TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL)\]?\s+)?`),
+	},
+}
+
+// LinePrefixNames lists the names ViewConfig.LinePrefix accepts.
+func LinePrefixNames() []string {
+	names := []string{LinePrefixAuto, LinePrefixNone}
+	for _, preset := range LinePrefixPresets {
+		names = append(names, preset.Name)
+	}
+	return names
+}
+
+// GetLinePrefixPreset returns the preset of the given name.
+func GetLinePrefixPreset(name string) (*LinePrefix, error) {
+	for _, preset := range LinePrefixPresets {
+		if preset.Name == name {
+			return preset, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown line prefix %q. Valid line prefixes: %q", name, LinePrefixNames())
+}
+
+// Maximum number of lines DetectLinePrefix should be given
+const LinePrefixSampleLines = 100
+
+// LinePrefixSampleTimeout is how long to wait for more lines after the first
+// one before detecting the prefix, so that a log that is still being written,
+// e.g. by tail -f, isn't held back until LinePrefixSampleLines lines arrive.
+const LinePrefixSampleTimeout = 200 * time.Millisecond
+
+// DetectLinePrefix returns the preset matching most of the non-empty sample
+// lines, or nil if no preset matches at least half of them.
+func DetectLinePrefix(lines []string) *LinePrefix {
+	var best *LinePrefix
+	bestCount, total := 0, 0
+	counts := make([]int, len(LinePrefixPresets))
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		total++
+		for i, preset := range LinePrefixPresets {
+			if preset.Regex.MatchString(line) {
+				counts[i]++
+			}
+		}
+	}
+	for i, preset := range LinePrefixPresets {
+		if counts[i] > bestCount {
+			best, bestCount = preset, counts[i]
+		}
+	}
+	if best == nil || bestCount*2 < total {
+		return nil
+	}
+	return best
+}
+
+// Name of the group of an envelope regex capturing the logged message
+const envelopeMessageGroup = "msg"
+
+// LineEnvelope is a user-defined regex splitting log lines into the logged
+// message, captured by the named group "msg", and fields captured by the other
+// named groups, e.g. time, level, thread and suffix.
+type LineEnvelope struct {
+	Regex *regexp.Regexp
+}
+
+// CompileLineEnvelope compiles an envelope regex, which must have a "msg" group.
+func CompileLineEnvelope(expr string) (*LineEnvelope, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envelope_regex %q: %w", expr, err)
+	}
+	if re.SubexpIndex(envelopeMessageGroup) < 0 {
+		return nil, fmt.Errorf("envelope_regex %q must contain a named group %q", expr, envelopeMessageGroup)
+	}
+	return &LineEnvelope{Regex: re}, nil
+}
+
+// Split splits line into the text before and after the logged message, and
+// returns the fields captured by the other named groups. The message is the
+// whole line and fields is nil if line doesn't match the envelope.
+func (e *LineEnvelope) Split(line string) (prefix string, msg string, suffix string, fields map[string]string) {
+	m := e.Regex.FindStringSubmatchIndex(line)
+	msgIdx := e.Regex.SubexpIndex(envelopeMessageGroup)
+	if m == nil || m[2*msgIdx] < 0 {
+		return "", line, "", nil
+	}
+	fields = make(map[string]string)
+	for i, name := range e.Regex.SubexpNames() {
+		if name != "" && name != envelopeMessageGroup && m[2*i] >= 0 {
+			fields[name] = line[m[2*i]:m[2*i+1]]
+		}
+	}
+	start, end := m[2*msgIdx], m[2*msgIdx+1]
+	return line[:start], line[start:end], line[end:], fields
+}
+
+// HasField reports whether the envelope captures the named field.
+func (e *LineEnvelope) HasField(name string) bool {
+	return name != envelopeMessageGroup && e.Regex.SubexpIndex(name) >= 0
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestLinePrefixPresets(t *testing.T) {
+	tests := []struct {
+		preset  string
+		line    string
+		wantMsg string
+	}{
+		{"syslog5424", `<34>1 2024-10-16T12:00:00Z host app 123 ID47 [exampleSDID@32473 iut="3" eventSource="App"] Failed login`, "Failed login"},
+		{"syslog5424", "1 2024-10-16T12:00:00Z host app 123 ID47 - Failed login", "Failed login"},
+		{"syslog", "Oct 16 12:00:00 host sshd[123]: Failed password for root", "Failed password for root"},
+		{"syslog", "<13>Oct  6 12:00:00 host kernel: eth0 up", "eth0 up"},
+		{"dmesg", "[   12.345678] usb 1-1: new device", "usb 1-1: new device"},
+		{"glog", "I1016 12:00:00.123456    1234 server.go:42] listening on :80", "listening on :80"},
+		{"logcat", "10-16 12:00:00.123  1234  5678 I ActivityManager: Start proc", "Start proc"},
+		{"log4j", "2024-10-16 12:00:00,123 [main] INFO  com.example.App - started in 3s", "started in 3s"},
+		{"log4j", "2024-10-16T12:00:00.123 ERROR com.example.App failed", "failed"},
+		{"python", "WARNING:root:disk almost full", "disk almost full"},
+		{"python", "ERROR:app.db:connection lost", "connection lost"},
+		{"iso8601", "2024-10-16T12:00:00.123Z [INFO] ready", "ready"},
+		{"iso8601", "2024-10-16 12:00:00+02:00 ready", "ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			preset, err := GetLinePrefixPreset(tt.preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefix, msg := preset.Split(tt.line)
+			if msg != tt.wantMsg || prefix+msg != tt.line {
+				t.Errorf("Split(%q) = %q, %q, want message %q", tt.line, prefix, msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestLinePrefixSplitWithoutPrefix(t *testing.T) {
+	preset, err := GetLinePrefixPreset("glog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix, msg := preset.Split("listening on :80"); prefix != "" || msg != "listening on :80" {
+		t.Errorf("Split = %q, %q, want the whole line as message", prefix, msg)
+	}
+	if _, err := GetLinePrefixPreset("nope"); err == nil {
+		t.Errorf("GetLinePrefixPreset(%q) succeeded, want error", "nope")
+	}
+}
+
+func TestDetectLinePrefix(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"no lines", nil, ""},
+		{"plain messages", []string{"starting", "listening on :80"}, ""},
+		{"all lines", []string{
+			"I1016 12:00:00.123456    1234 server.go:42] starting",
+			"W1016 12:00:01.000001    1234 server.go:50] slow request",
+		}, "glog"},
+		{"empty lines are ignored", []string{"", "[    1.000000] booting", "  ", "[    2.000000] ready"}, "dmesg"},
+		{"half of the lines", []string{"Oct 16 12:00:00 host sshd[1]: a", "continued line"}, "syslog"},
+		{"less than half of the lines", []string{"Oct 16 12:00:00 host sshd[1]: a", "continued", "continued"}, ""},
+		{"most matching preset", []string{
+			"2024-10-16T12:00:00Z ready",
+			"2024-10-16T12:00:01Z listening",
+			"WARNING:root:disk almost full",
+		}, "iso8601"},
+		// log4j lines match iso8601 as well
+		{"more specific preset on ties", []string{
+			"2024-10-16 12:00:00,123 [main] INFO  com.example.App - started",
+			"2024-10-16 12:00:01,456 [main] WARN  com.example.Db - slow query",
+		}, "log4j"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if preset := DetectLinePrefix(tt.lines); preset != nil {
+				got = preset.Name
+			}
+			if got != tt.want {
+				t.Errorf("DetectLinePrefix = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineEnvelopeSplit(t *testing.T) {
+	envelope, err := CompileLineEnvelope(`^(?P<time>\S+) (?P<level>[A-Z]+) (?P<msg>.*?)(?P<suffix> \{.*\})?$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line                            string
+		wantPrefix, wantMsg, wantSuffix string
+		wantFields                      map[string]string
+	}{
+		{"12:00 INFO started {req=1}", "12:00 INFO ", "started", " {req=1}",
+			map[string]string{"time": "12:00", "level": "INFO", "suffix": " {req=1}"}},
+		{"12:00 WARN slow", "12:00 WARN ", "slow", "",
+			map[string]string{"time": "12:00", "level": "WARN"}},
+		{"no envelope", "", "no envelope", "", nil},
+	}
+	for _, tt := range tests {
+		prefix, msg, suffix, fields := envelope.Split(tt.line)
+		if prefix != tt.wantPrefix || msg != tt.wantMsg || suffix != tt.wantSuffix {
+			t.Errorf("Split(%q) = %q, %q, %q, want %q, %q, %q", tt.line, prefix, msg, suffix, tt.wantPrefix, tt.wantMsg, tt.wantSuffix)
+		}
+		if !maps.Equal(fields, tt.wantFields) || (fields == nil) != (tt.wantFields == nil) {
+			t.Errorf("fields of %q = %v, want %v", tt.line, fields, tt.wantFields)
+		}
+	}
+	if _, err := CompileLineEnvelope(`^(?P<time>\S+) (.*)$`); err == nil {
+		t.Errorf("CompileLineEnvelope without a msg group succeeded, want error")
+	}
+}
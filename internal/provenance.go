@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/phuslu/log"
+)
+
+// LogalignVersion is the version of logalign stamped into built corpora. It may
+// be set with -ldflags "-X github.com/htfy96/logalign/internal.LogalignVersion=v1.2.3",
+// and defaults to the module version and VCS revision of the build.
+var LogalignVersion = ""
+
+func logalignVersion() string {
+	if LogalignVersion != "" {
+		return LogalignVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	if version != "" && version != "(devel)" {
+		return version
+	}
+	// Built from a checkout without a module version
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			revision = setting.Value
+		} else if setting.Key == "vcs.modified" {
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return version
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// Provenance records how a corpus file was built.
+type Provenance struct {
+	LogalignVersion string `json:"logalign_version"`
+	// Absolute path of the repo the corpus was built from
+	RepoRoot string `json:"repo_root"`
+	// Git commit checked out in the working directory, or the built revision
+	Head string `json:"head,omitempty"`
+	// Whether the working directory had uncommitted changes
+	Dirty bool `json:"dirty,omitempty"`
+	// Hash of all definition files of the workspace
+	ConfigHash string `json:"config_hash"`
+}
+
+// newProvenance describes a build of sourceTree, read from repoRoot with configs.
+func newProvenance(repoRoot string, sourceTree SourceTree, configs []*subtreeConfig) Provenance {
+	p := Provenance{
+		LogalignVersion: logalignVersion(),
+		RepoRoot:        repoRoot,
+		Head:            sourceTree.Revision(),
+		ConfigHash:      configHash(configs),
+	}
+	if absRoot, err := filepath.Abs(repoRoot); err == nil {
+		p.RepoRoot = absRoot
+	}
+	if p.Head == "" {
+		p.Head, p.Dirty = workingTreeState(repoRoot)
+	}
+	return p
+}
+
+// workingTreeState returns the commit checked out in repoRoot and whether there
+// are uncommitted changes. The commit is empty if repoRoot is not a git repo.
+func workingTreeState(repoRoot string) (string, bool) {
+	out, err := exec.Command("git", "-C", repoRoot, "rev-parse", "--verify", "HEAD").Output()
+	if err != nil {
+		log.Debug().Msgf("Not recording git HEAD of %s: %v", repoRoot, err)
+		return "", false
+	}
+	head := strings.TrimSpace(string(out))
+	status, err := exec.Command("git", "-C", repoRoot, "status", "--porcelain").Output()
+	if err != nil {
+		log.Warn().Msgf("error getting git status of %s: %v", repoRoot, err)
+		return head, false
+	}
+	return head, len(strings.TrimSpace(string(status))) > 0
+}
+
+func configHash(configs []*subtreeConfig) string {
+	hash := sha256.New()
+	for _, cfg := range configs {
+		hash.Write([]byte(cfg.Root))
+		hash.Write([]byte{0})
+		hash.Write(cfg.data)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ComputeContentHash returns a hash of the definitions and calls of the corpus
+// file, which is the same for two builds of the same sources.
+func (c *CorpusFile) ComputeContentHash() (string, error) {
+	data, err := json.Marshal(struct {
+		Definitions []LogCallDefinition `json:"definitions"`
+		Calls       []LogCall           `json:"calls"`
+	}{c.Definitions, c.Calls})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
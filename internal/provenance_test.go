@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigHash(t *testing.T) {
+	hashOf := func(files map[string]string) string {
+		t.Helper()
+		tree := &fakeSourceTree{files: files}
+		allFiles, err := tree.ListFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs, err := loadWorkspaceConfigs(tree, allFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeSubtreeConfigs(configs)
+		return configHash(configs)
+	}
+	nested := map[string]string{
+		"a/.logalign.toml": testDefinitionFile("", "a"),
+		"b/.logalign.toml": testDefinitionFile("", "b"),
+	}
+	withRoot := func(root string, extra map[string]string) map[string]string {
+		files := map[string]string{".logalign.toml": root}
+		for name, content := range nested {
+			files[name] = content
+		}
+		for name, content := range extra {
+			files[name] = content
+		}
+		return files
+	}
+	hash := hashOf(withRoot("project = \"mono\"\nroots = ['a', 'b']", nil))
+	tests := []struct {
+		name  string
+		files map[string]string
+		same  bool
+	}{
+		{"same files", withRoot("project = \"mono\"\nroots = ['a', 'b']", nil), true},
+		{"source files changed", withRoot("project = \"mono\"\nroots = ['a', 'b']", map[string]string{"a/main.c": "int main() {}"}), true},
+		{"nested definition file changed", withRoot("project = \"mono\"\nroots = ['a', 'b']", map[string]string{"b/.logalign.toml": testDefinitionFile("", "b", "b2")}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashOf(tt.files); (got == hash) != tt.same {
+				t.Errorf("config hash %s, original %s, want same: %v", got, hash, tt.same)
+			}
+		})
+	}
+}
+
+func TestComputeContentHash(t *testing.T) {
+	builtAt := time.Unix(1700000000, 0)
+	c := testCorpusFile("app", "v1", builtAt, "started")
+	hash, err := c.ComputeContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another build of the same sources, at another time and place
+	rebuilt := testCorpusFile("app", "v2", builtAt.Add(time.Hour), "started")
+	rebuilt.Provenance = Provenance{RepoRoot: "/elsewhere", Head: "c0ffee", LogalignVersion: "v9"}
+	if got, err := rebuilt.ComputeContentHash(); err != nil || got != hash {
+		t.Errorf("content hash of a rebuild = %s, %v, want %s", got, err, hash)
+	}
+	changed := testCorpusFile("app", "v1", builtAt, "stopped")
+	if got, err := changed.ComputeContentHash(); err != nil || got == hash {
+		t.Errorf("content hash of other calls = %s, %v, want a different hash", got, err)
+	}
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/phuslu/log"
+)
+
+// Link styles of git hosting services, see deriveLinkTemplate
+const (
+	LinkStyleGitHub      = "github"
+	LinkStyleGitLab      = "gitlab"
+	LinkStyleGitea       = "gitea"
+	LinkStyleBitbucket   = "bitbucket"
+	LinkStyleSourcegraph = "sourcegraph"
+	LinkStyleCgit        = "cgit"
+	// Don't derive link templates
+	LinkStyleNone = "none"
+)
+
+// Link templates of each style, given the web base URL of the repo and the commit
+var linkStyleTemplates = map[string]string{
+	LinkStyleGitHub:      "%s/blob/%s/{file|urlpath}#L{line}-L{end_line}",
+	LinkStyleGitLab:      "%s/-/blob/%s/{file|urlpath}#L{line}-{end_line}",
+	LinkStyleGitea:       "%s/src/commit/%s/{file|urlpath}#L{line}-L{end_line}",
+	LinkStyleBitbucket:   "%s/src/%s/{file|urlpath}#lines-{line}:{end_line}",
+	LinkStyleSourcegraph: "%s@%s/-/blob/{file|urlpath}?L{line}-{end_line}",
+	LinkStyleCgit:        "%s/tree/{file|urlpath}?id=%s#n{line}",
+}
+
+// remoteWebURL returns the web URL of the repo behind a git remote URL, e.g.
+// https://github.com/owner/repo for git@github.com:owner/repo.git, along with
+// its host. Credentials and ports of SSH remotes are dropped.
+func remoteWebURL(remote string) (string, string, error) {
+	var scheme, host, repoPath string
+	if !strings.Contains(remote, "://") {
+		// scp-like syntax: [user@]host:path
+		userHost, p, ok := strings.Cut(remote, ":")
+		if !ok {
+			return "", "", fmt.Errorf("unsupported remote URL %q", remote)
+		}
+		_, host, ok = strings.Cut(userHost, "@")
+		if !ok {
+			host = userHost
+		}
+		scheme, repoPath = "https", p
+	} else {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid remote URL %q: %w", remote, err)
+		}
+		scheme, host, repoPath = u.Scheme, u.Host, u.Path
+		if scheme != "http" && scheme != "https" {
+			scheme, host = "https", u.Hostname()
+		}
+	}
+	repoPath = strings.Trim(repoPath, "/")
+	if host == "" || repoPath == "" {
+		return "", "", fmt.Errorf("unsupported remote URL %q", remote)
+	}
+	return scheme + "://" + host + "/" + repoPath, host, nil
+}
+
+// detectLinkStyle guesses the hosting service of a repo from its URL.
+func detectLinkStyle(host string, webURL string) string {
+	host = strings.ToLower(host)
+	switch {
+	case strings.Contains(host, "github"):
+		return LinkStyleGitHub
+	case strings.Contains(host, "gitlab"):
+		return LinkStyleGitLab
+	case strings.Contains(host, "gitea"), strings.Contains(host, "codeberg"), strings.Contains(host, "forgejo"):
+		return LinkStyleGitea
+	case strings.Contains(host, "bitbucket"):
+		return LinkStyleBitbucket
+	case strings.Contains(host, "sourcegraph"):
+		return LinkStyleSourcegraph
+	case strings.Contains(host, "cgit"), host == "git.kernel.org", strings.Contains(webURL, "/cgit/"):
+		return LinkStyleCgit
+	}
+	return ""
+}
+
+// deriveLinkTemplate returns a link template pointing to commit of the repo
+// behind a git remote URL. The style of the links is detected from the URL
+// unless given.
+func deriveLinkTemplate(remote string, commit string, style string) (string, error) {
+	webURL, host, err := remoteWebURL(remote)
+	if err != nil {
+		return "", err
+	}
+	if style == "" {
+		style = detectLinkStyle(host, webURL)
+		if style == "" {
+			return "", fmt.Errorf("unknown git hosting service of %s. Set link_style", webURL)
+		}
+	}
+	template, ok := linkStyleTemplates[style]
+	if !ok {
+		return "", fmt.Errorf("unknown link_style %q", style)
+	}
+	// cgit serves repos under their path with .git, other services without
+	if style != LinkStyleCgit {
+		webURL = strings.TrimSuffix(webURL, ".git")
+	}
+	escape := strings.NewReplacer("{", "{{", "}", "}}")
+	return fmt.Sprintf(template, escape.Replace(webURL), escape.Replace(commit)), nil
+}
+
+// gitRemoteURL returns the URL of the origin remote of repoRoot, or of its
+// first remote if there is no origin. It is empty if there is no remote.
+func gitRemoteURL(repoRoot string) string {
+	out, err := exec.Command("git", "-C", repoRoot, "remote").Output()
+	if err != nil {
+		log.Debug().Msgf("Not reading git remotes of %s: %v", repoRoot, err)
+		return ""
+	}
+	remotes := strings.Fields(string(out))
+	if len(remotes) == 0 {
+		return ""
+	}
+	remote := remotes[0]
+	for _, r := range remotes {
+		if r == "origin" {
+			remote = r
+		}
+	}
+	out, err = exec.Command("git", "-C", repoRoot, "remote", "get-url", remote).Output()
+	if err != nil {
+		log.Warn().Msgf("error getting URL of git remote %s of %s: %v", remote, repoRoot, err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// deriveLinkTemplates sets the link_template of the definitions of c that
+// don't have one, to point to the commit the corpus was built from on the
+// hosting service of the git remote of repoRoot.
+func (c *CorpusFile) deriveLinkTemplates(repoRoot string, style string) {
+	if style == LinkStyleNone || !slices.ContainsFunc(c.Definitions, func(def LogCallDefinition) bool { return def.LinkTemplate == "" }) {
+		return
+	}
+	commit := c.Revision
+	if commit == "" {
+		commit = c.Provenance.Head
+	}
+	remote := gitRemoteURL(repoRoot)
+	if commit == "" || remote == "" {
+		return
+	}
+	template, err := deriveLinkTemplate(remote, commit, style)
+	if err != nil {
+		log.Warn().Msgf("Not deriving link_template of project %s: %v", c.Project, err)
+		return
+	}
+	if c.Provenance.Dirty {
+		log.Warn().Msgf("Derived links of project %s point to commit %s, but the working directory has uncommitted changes", c.Project, commit)
+	}
+	for i := range c.Definitions {
+		if c.Definitions[i].LinkTemplate == "" {
+			c.Definitions[i].LinkTemplate = template
+			log.Info().Msgf("Derived link_template %s for definition %s of project %s", template, c.Definitions[i].ID, c.Project)
+		}
+	}
+}
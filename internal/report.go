@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// DropReason is why a match of a log call definition was not added to the corpus.
+type DropReason string
+
+const (
+	DropReasonNoMethod          DropReason = "no_method"
+	DropReasonNoFormatString    DropReason = "no_format_string"
+	DropReasonUnsupportedSyntax DropReason = "unsupported_syntax"
+	DropReasonInvalidFormat     DropReason = "invalid_format"
+	DropReasonArgCountMismatch  DropReason = "arg_count_mismatch"
+)
+
+var allDropReasons = []DropReason{
+	DropReasonNoMethod,
+	DropReasonNoFormatString,
+	DropReasonUnsupportedSyntax,
+	DropReasonInvalidFormat,
+	DropReasonArgCountMismatch,
+}
+
+// Number of example locations kept per definition and reason
+const maxReportExamples = 5
+
+// Diagnostic is a single problem found while extracting log calls.
+// DefinitionID and Reason are empty for file-level problems.
+type Diagnostic struct {
+	Project      string     `json:"project"`
+	DefinitionID string     `json:"definition_id,omitempty"`
+	Reason       DropReason `json:"reason,omitempty"`
+	File         string     `json:"file"`
+	Line         int        `json:"line"`
+	Detail       string     `json:"detail,omitempty"`
+}
+
+type DiagnosticStats struct {
+	Count    int          `json:"count"`
+	Examples []Diagnostic `json:"examples"`
+}
+
+func (s *DiagnosticStats) add(diag Diagnostic) {
+	s.Count++
+	if len(s.Examples) < maxReportExamples {
+		s.Examples = append(s.Examples, diag)
+	}
+}
+
+// DefinitionReport counts the matches of a single log call definition.
+type DefinitionReport struct {
+	Project      string                          `json:"project"`
+	DefinitionID string                          `json:"definition_id"`
+	Kept         int                             `json:"kept"`
+	Dropped      map[DropReason]*DiagnosticStats `json:"dropped"`
+}
+
+func (r *DefinitionReport) DroppedCount() int {
+	cnt := 0
+	for _, stats := range r.Dropped {
+		cnt += stats.Count
+	}
+	return cnt
+}
+
+// BuildReport summarizes the coverage of a corpus build.
+type BuildReport struct {
+	FilesScanned int `json:"files_scanned"`
+	FilesFailed  int `json:"files_failed"`
+	// Files where tree-sitter produced ERROR nodes. Log calls inside them may be missing.
+	SyntaxErrors DiagnosticStats     `json:"syntax_errors"`
+	Definitions  []*DefinitionReport `json:"definitions"`
+}
+
+func (r *BuildReport) definitionReport(project string, definitionID string) *DefinitionReport {
+	for _, defReport := range r.Definitions {
+		if defReport.Project == project && defReport.DefinitionID == definitionID {
+			return defReport
+		}
+	}
+	defReport := &DefinitionReport{
+		Project:      project,
+		DefinitionID: definitionID,
+		Dropped:      make(map[DropReason]*DiagnosticStats),
+	}
+	r.Definitions = append(r.Definitions, defReport)
+	return defReport
+}
+
+func (r *BuildReport) addCalls(calls []LogCall) {
+	for _, call := range calls {
+		r.definitionReport(call.Project, call.DefinitionID).Kept++
+	}
+}
+
+func (r *BuildReport) addDiagnostics(diags []Diagnostic) {
+	for _, diag := range diags {
+		if diag.Reason == "" {
+			r.SyntaxErrors.add(diag)
+			continue
+		}
+		defReport := r.definitionReport(diag.Project, diag.DefinitionID)
+		if _, ok := defReport.Dropped[diag.Reason]; !ok {
+			defReport.Dropped[diag.Reason] = &DiagnosticStats{}
+		}
+		defReport.Dropped[diag.Reason].add(diag)
+	}
+}
+
+// DropRate returns the ratio of dropped matches to all matches of all definitions.
+func (r *BuildReport) DropRate() float64 {
+	kept, dropped := 0, 0
+	for _, defReport := range r.Definitions {
+		kept += defReport.Kept
+		dropped += defReport.DroppedCount()
+	}
+	if kept+dropped == 0 {
+		return 0
+	}
+	return float64(dropped) / float64(kept+dropped)
+}
+
+// WriteSummary writes a table of kept and dropped matches per definition.
+func (r *BuildReport) WriteSummary(w io.Writer) error {
+	slices.SortFunc(r.Definitions, func(a, b *DefinitionReport) int {
+		if c := strings.Compare(a.Project, b.Project); c != 0 {
+			return c
+		}
+		return strings.Compare(a.DefinitionID, b.DefinitionID)
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "PROJECT\tDEFINITION\tKEPT\t")
+	for _, reason := range allDropReasons {
+		fmt.Fprintf(tw, "%s\t", strings.ToUpper(string(reason)))
+	}
+	fmt.Fprintln(tw, "DROP RATE\t")
+	for _, defReport := range r.Definitions {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t", defReport.Project, defReport.DefinitionID, defReport.Kept)
+		for _, reason := range allDropReasons {
+			cnt := 0
+			if stats, ok := defReport.Dropped[reason]; ok {
+				cnt = stats.Count
+			}
+			fmt.Fprintf(tw, "%d\t", cnt)
+		}
+		dropRate := 0.0
+		if total := defReport.Kept + defReport.DroppedCount(); total > 0 {
+			dropRate = float64(defReport.DroppedCount()) / float64(total)
+		}
+		fmt.Fprintf(tw, "%.1f%%\t\n", dropRate*100)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Scanned %d files, %d failed, %d with syntax errors. Overall drop rate: %.1f%%\n",
+		r.FilesScanned, r.FilesFailed, r.SyntaxErrors.Count, r.DropRate()*100)
+	return err
+}
+
+// Save writes the report as indented JSON to filePath.
+func (r *BuildReport) Save(filePath string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling build report: %w", err)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("error writing build report: %w", err)
+	}
+	return nil
+}
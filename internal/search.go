@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CallQuery selects the log calls returned by SearchCalls. Empty fields match
+// all calls.
+type CallQuery struct {
+	// Regex on the format string
+	Format string
+	// "{file}:{line}" selects the calls spanning that line, and anything else
+	// the calls in files with that path prefix
+	Location string
+	// Regex on the method
+	Method string
+	// Normalized levels, see AllLogLevels
+	Levels       []string
+	DefinitionID string
+	// Substring of any argument expression
+	Argument string
+}
+
+// callMatcher is a compiled CallQuery.
+type callMatcher struct {
+	query    CallQuery
+	format   *regexp.Regexp
+	method   *regexp.Regexp
+	file     string
+	line     int
+	isPrefix bool
+}
+
+func (q CallQuery) compile() (*callMatcher, error) {
+	m := &callMatcher{query: q, file: q.Location, isPrefix: true}
+	var err error
+	if m.format, err = regexp.Compile(q.Format); err != nil {
+		return nil, fmt.Errorf("invalid format regex: %w", err)
+	}
+	if m.method, err = regexp.Compile(q.Method); err != nil {
+		return nil, fmt.Errorf("invalid method regex: %w", err)
+	}
+	for _, level := range q.Levels {
+		if !slices.Contains(AllLogLevels, level) {
+			return nil, fmt.Errorf("invalid level %q. Valid levels: %q", level, AllLogLevels)
+		}
+	}
+	if i := strings.LastIndexByte(q.Location, ':'); i >= 0 {
+		if line, err := strconv.Atoi(q.Location[i+1:]); err == nil {
+			m.file, m.line, m.isPrefix = q.Location[:i], line, false
+		}
+	}
+	return m, nil
+}
+
+func (m *callMatcher) matches(call *LogCall) bool {
+	if !m.format.MatchString(call.FormatString) || !m.method.MatchString(call.Method) {
+		return false
+	}
+	if len(m.query.Levels) > 0 && !slices.Contains(m.query.Levels, call.NormalizedLevel()) {
+		return false
+	}
+	if m.query.DefinitionID != "" && call.DefinitionID != m.query.DefinitionID {
+		return false
+	}
+	if m.query.Argument != "" && !slices.ContainsFunc(call.ArgumentExprs, func(expr string) bool {
+		return strings.Contains(expr, m.query.Argument)
+	}) {
+		return false
+	}
+	if m.isPrefix {
+		return strings.HasPrefix(call.File, m.file)
+	}
+	return call.File == m.file && call.Line <= m.line && m.line <= max(call.EndLine, call.Line)
+}
+
+// SearchCalls returns the calls selected by query, in their original order.
+func SearchCalls(calls []LogCall, query CallQuery) ([]LogCall, error) {
+	m, err := query.compile()
+	if err != nil {
+		return nil, err
+	}
+	results := []LogCall{}
+	for i := range calls {
+		if m.matches(&calls[i]) {
+			results = append(results, calls[i])
+		}
+	}
+	return results, nil
+}
+
+// SampleLineMatch is a log call whose pattern matches a sample log line.
+type SampleLineMatch struct {
+	Call    LogCall `json:"call"`
+	Version string  `json:"version"`
+	// Number of characters matched by the pattern, and how many of them are
+	// outside of arguments
+	MatchedTotal    int `json:"matched_total"`
+	MatchedLiterals int `json:"matched_literals"`
+	// Whether the match passes the thresholds of the viewer. 'logalign view'
+	// attributes the line to the first confident match
+	Confident bool `json:"confident"`
+}
+
+// MatchSampleLine returns the calls of v.DefaultVersions matching line that are
+// selected by query, best match first. line is the logged text without prefix.
+func (v *Viewer) MatchSampleLine(line string, query CallQuery) ([]SampleLineMatch, error) {
+	m, err := query.compile()
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := v.AllocScratch()
+	if err != nil {
+		return nil, fmt.Errorf("error allocating hyperscan scratch: %w", err)
+	}
+	defer scratch.Free()
+	candidates, err := v.FindCandidates(line, scratch, v.DefaultVersions)
+	if err != nil {
+		return nil, err
+	}
+	results := []SampleLineMatch{}
+	for _, c := range candidates {
+		call := v.getLogCallFromRef(c.LcRef)
+		if !m.matches(call) {
+			continue
+		}
+		results = append(results, SampleLineMatch{
+			Call:            *call,
+			Version:         c.LcRef.Version,
+			MatchedTotal:    c.MatchedTotal,
+			MatchedLiterals: c.MatchedLiterals,
+			Confident:       v.IsConfident(c, len(line)),
+		})
+	}
+	return results, nil
+}
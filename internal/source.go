@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/phuslu/log"
+)
+
+// SourceTree is where the logcall definition file and the source files of a
+// repository are read from during a corpus build.
+type SourceTree interface {
+	// ListFiles returns the sorted paths of all regular files, relative to the tree root.
+	ListFiles() ([]string, error)
+	// ReadFile returns the content of a file listed by ListFiles.
+	ReadFile(path string) ([]byte, error)
+	// Revision returns the commit the tree is read from, or "" for a working directory.
+	Revision() string
+	// Blame returns the output of git blame --porcelain for the given 1-indexed,
+	// inclusive line ranges of a file.
+	Blame(path string, ranges [][2]int) ([]byte, error)
+	Close() error
+}
+
+func blameArgs(ranges [][2]int) []string {
+	args := []string{"blame", "--porcelain"}
+	for _, r := range ranges {
+		args = append(args, "-L", fmt.Sprintf("%d,%d", r[0], r[1]))
+	}
+	return args
+}
+
+// OpenSourceTree opens repoRoot for reading. If rev is empty, the files are
+// read from the working directory. Otherwise they are read from the git object
+// database at rev, where repoRoot may be a work tree, a bare repo or a bundle.
+func OpenSourceTree(repoRoot string, rev string) (SourceTree, error) {
+	if rev == "" {
+		return &dirSourceTree{root: repoRoot}, nil
+	}
+	return openGitRevSourceTree(repoRoot, rev)
+}
+
+type dirSourceTree struct {
+	root string
+}
+
+func (t *dirSourceTree) ListFiles() ([]string, error) {
+	sourceFiles := []string{}
+	// The root may also be a subdirectory of a work tree. Paths are relative to
+	// the root rather than to the top of the work tree, like the paths of ReadFile and Blame
+	if exec.Command("git", "-C", t.root, "rev-parse", "--is-inside-work-tree").Run() == nil {
+		log.Debug().Msgf("Building corpus from Git repository at %s", t.root)
+		cmd := exec.Command("git", "-C", t.root, "ls-files", "-z")
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("error running git command: %w", err)
+		}
+		for _, file := range strings.Split(string(out), "\x00") {
+			if file != "" {
+				sourceFiles = append(sourceFiles, file)
+			}
+		}
+		return sourceFiles, nil
+	}
+	log.Debug().Msgf("Building corpus from local directory at %s", t.root)
+	err := filepath.Walk(t.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Warn().Msgf("error walking directory %s: %s", path, err)
+			return err
+		}
+		if !info.IsDir() {
+			path, _ = strings.CutPrefix(path, t.root)
+			path = strings.TrimPrefix(path, "/")
+			sourceFiles = append(sourceFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory %s: %w", t.root, err)
+	}
+	return sourceFiles, nil
+}
+
+func (t *dirSourceTree) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(t.root, path))
+}
+
+func (t *dirSourceTree) Revision() string {
+	return ""
+}
+func (t *dirSourceTree) Blame(path string, ranges [][2]int) ([]byte, error) {
+	args := append([]string{"-C", t.root}, blameArgs(ranges)...)
+	out, err := exec.Command("git", append(args, "--", path)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running git blame on %s: %w", path, err)
+	}
+	return out, nil
+}
+
+func (t *dirSourceTree) Close() error {
+	return nil
+}
+
+// gitRevSourceTree reads files of a single commit through a long-running
+// `git cat-file --batch` process, so no checkout is required.
+type gitRevSourceTree struct {
+	gitDir string
+	commit string
+	// Only set when gitDir is a temporary clone of a bundle
+	tempDir string
+	// path ==> blob object id
+	blobs map[string]string
+
+	// Idle git cat-file --batch processes. Each concurrent ReadFile uses its own
+	// process, so that the build workers don't wait for each other
+	mu       sync.Mutex
+	idleCats []*catFileProcess
+	allCats  []*catFileProcess
+}
+
+// catFileProcess is a running git cat-file --batch.
+type catFileProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func startCatFile(cmd *exec.Cmd) (*catFileProcess, error) {
+	p := &catFileProcess{cmd: cmd}
+	var err error
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("error creating pipe to git cat-file: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		p.stdin.Close()
+		return nil, fmt.Errorf("error creating pipe from git cat-file: %w", err)
+	}
+	p.stdout = bufio.NewReader(stdout)
+	if err := cmd.Start(); err != nil {
+		p.stdin.Close()
+		return nil, fmt.Errorf("error starting git cat-file: %w", err)
+	}
+	return p, nil
+}
+
+func (p *catFileProcess) close() {
+	p.stdin.Close()
+	p.cmd.Wait()
+}
+
+// acquireCatFile returns an idle git cat-file process, or starts a new one.
+func (t *gitRevSourceTree) acquireCatFile() (*catFileProcess, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.idleCats); n > 0 {
+		p := t.idleCats[n-1]
+		t.idleCats = t.idleCats[:n-1]
+		return p, nil
+	}
+	p, err := startCatFile(t.git("cat-file", "--batch"))
+	if err != nil {
+		return nil, err
+	}
+	t.allCats = append(t.allCats, p)
+	return p, nil
+}
+
+func (t *gitRevSourceTree) releaseCatFile(p *catFileProcess) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.idleCats = append(t.idleCats, p)
+}
+
+func isGitBundle(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return exec.Command("git", "bundle", "list-heads", path).Run() == nil
+}
+
+func openGitRevSourceTree(repoRoot string, rev string) (*gitRevSourceTree, error) {
+	t := &gitRevSourceTree{gitDir: repoRoot}
+	if isGitBundle(repoRoot) {
+		tempDir, err := os.MkdirTemp("", "logalign-bundle-")
+		if err != nil {
+			return nil, fmt.Errorf("error creating temporary directory for bundle: %w", err)
+		}
+		log.Debug().Msgf("Unpacking git bundle %s to %s", repoRoot, tempDir)
+		out, err := exec.Command("git", "clone", "--bare", "--quiet", repoRoot, tempDir).CombinedOutput()
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return nil, fmt.Errorf("error unpacking git bundle %s: %w: %s", repoRoot, err, out)
+		}
+		t.gitDir = tempDir
+		t.tempDir = tempDir
+	}
+
+	out, err := t.git("rev-parse", "--verify", "--end-of-options", rev+"^{commit}").Output()
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("error resolving revision %q in %s: %w", rev, repoRoot, err)
+	}
+	t.commit = strings.TrimSpace(string(out))
+	log.Debug().Msgf("Building corpus from revision %s (%s) of %s", rev, t.commit, repoRoot)
+
+	if err := t.loadBlobs(); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	// Fails early if git cat-file can't run at all
+	p, err := t.acquireCatFile()
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.releaseCatFile(p)
+	return t, nil
+}
+
+func (t *gitRevSourceTree) git(args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"-C", t.gitDir}, args...)...)
+}
+
+func (t *gitRevSourceTree) loadBlobs() error {
+	out, err := t.git("ls-tree", "-r", "-z", "--full-tree", t.commit).Output()
+	if err != nil {
+		return fmt.Errorf("error listing files of %s: %w", t.commit, err)
+	}
+	t.blobs = make(map[string]string)
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, path, ok := strings.Cut(string(entry), "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		// Skip submodules and symlinks
+		if len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		t.blobs[path] = fields[2]
+	}
+	return nil
+}
+
+func (t *gitRevSourceTree) ListFiles() ([]string, error) {
+	files := make([]string, 0, len(t.blobs))
+	for path := range t.blobs {
+		files = append(files, path)
+	}
+	// Keeps builds from the same revision reproducible
+	slices.Sort(files)
+	return files, nil
+}
+
+func (t *gitRevSourceTree) ReadFile(path string) ([]byte, error) {
+	oid, ok := t.blobs[path]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist at revision %s: %w", path, t.commit, os.ErrNotExist)
+	}
+	p, err := t.acquireCatFile()
+	if err != nil {
+		return nil, err
+	}
+	content, err := p.readBlob(path, oid)
+	if err != nil {
+		// The process may be out of sync with its requests, so it isn't reused
+		return nil, err
+	}
+	t.releaseCatFile(p)
+	return content, nil
+}
+
+// readBlob reads a blob through git cat-file --batch.
+func (p *catFileProcess) readBlob(path string, oid string) ([]byte, error) {
+	if _, err := fmt.Fprintln(p.stdin, oid); err != nil {
+		return nil, fmt.Errorf("error requesting %s from git cat-file: %w", path, err)
+	}
+	// <object> SP <type> SP <size> LF <contents> LF
+	header, err := p.stdout.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from git cat-file: %w", path, err)
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected git cat-file response for %s: %q", path, header)
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected git cat-file response for %s: %q", path, header)
+	}
+	content := make([]byte, size+1)
+	if _, err := io.ReadFull(p.stdout, content); err != nil {
+		return nil, fmt.Errorf("error reading %s from git cat-file: %w", path, err)
+	}
+	return content[:size], nil
+}
+
+func (t *gitRevSourceTree) Revision() string {
+	return t.commit
+}
+
+func (t *gitRevSourceTree) Blame(path string, ranges [][2]int) ([]byte, error) {
+	args := append(blameArgs(ranges), t.commit, "--", path)
+	out, err := t.git(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running git blame on %s at %s: %w", path, t.commit, err)
+	}
+	return out, nil
+}
+
+func (t *gitRevSourceTree) Close() error {
+	t.mu.Lock()
+	for _, p := range t.allCats {
+		p.close()
+	}
+	t.allCats, t.idleCats = nil, nil
+	t.mu.Unlock()
+	if t.tempDir != "" {
+		return os.RemoveAll(t.tempDir)
+	}
+	return nil
+}
@@ -0,0 +1,566 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/phuslu/log"
+)
+
+// Corpus files start with this magic line followed by the format version,
+// and a gzip-compressed JSON encoding of the CorpusFile.
+const corpusFileMagic = "LOGALIGN-CORPUS"
+
+// CorpusFormatVersion is the version of the corpus file format written by this build.
+// Files with a newer version are rejected.
+const CorpusFormatVersion = 1
+
+const CorpusFileSuffix = ".corpus"
+
+// Corpus files of earlier releases, written as indented JSON
+const legacyCorpusFileSuffix = ".json"
+
+// CorpusManifestFileName is the index of all corpus files in CorpusDir.
+const CorpusManifestFileName = "manifest.json"
+
+// ManifestEntry describes a corpus file without its definitions and calls.
+type ManifestEntry struct {
+	Project     string    `json:"project"`
+	Version     string    `json:"version,omitempty"`
+	Revision    string    `json:"revision,omitempty"`
+	BuiltAt     time.Time `json:"built_at"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	// SHA-256 of the corpus file, verified when it is loaded
+	Checksum   string     `json:"checksum,omitempty"`
+	Calls      int        `json:"calls"`
+	Provenance Provenance `json:"provenance"`
+	// Name of the corpus file in Dir
+	File string `json:"file"`
+	// Corpus directory the entry was read from, see CorpusLayers
+	Dir string `json:"-"`
+}
+
+// Path returns the path of the corpus file of the entry.
+func (e ManifestEntry) Path() string {
+	return filepath.Join(e.Dir, e.File)
+}
+
+// CorpusManifest lists the corpus files in CorpusDir, so that commands can
+// find the projects they need without reading every corpus file.
+type CorpusManifest struct {
+	FormatVersion int             `json:"format_version"`
+	Entries       []ManifestEntry `json:"entries"`
+	// Corpus files to delete after the manifest is saved, see updateManifest
+	obsoleteFiles []string
+	// Temporary files moved to their corpus file paths before the manifest is
+	// saved, see stageFile
+	stagedFiles []stagedFile
+}
+
+// stagedFile is a temporary file that replaces the corpus file at path.
+type stagedFile struct {
+	tmpPath string
+	path    string
+}
+
+// CorpusPath lists additional, possibly read-only corpus directories, e.g. a
+// system-wide or team-shared one. Earlier directories have lower precedence,
+// and CorpusDir takes precedence over all of them.
+var CorpusPath []string
+
+// CorpusLayers returns all corpus directories, lowest precedence first. Only
+// the last one, CorpusDir, is written to.
+func CorpusLayers() []string {
+	layers := []string{}
+	for _, dir := range CorpusPath {
+		if dir != "" && filepath.Clean(dir) != filepath.Clean(CorpusDir) && !slices.Contains(layers, dir) {
+			layers = append(layers, dir)
+		}
+	}
+	return append(layers, CorpusDir)
+}
+
+func manifestPath(dir string) string {
+	return filepath.Join(dir, CorpusManifestFileName)
+}
+
+// Advisory lock file of CorpusDir. Writers of corpus files and the manifest
+// hold it exclusively, readers hold it shared.
+const corpusLockFileName = ".lock"
+
+// lockCorpusDir takes the advisory lock of CorpusDir and returns a function
+// releasing it.
+func lockCorpusDir(exclusive bool) (func(), error) {
+	return lockDir(CorpusDir, exclusive)
+}
+
+// lockDir takes the advisory lock of a corpus directory. A shared lock of a
+// read-only directory without a lock file is skipped, as nobody can write to it.
+func lockDir(dir string, exclusive bool) (func(), error) {
+	lockPath := filepath.Join(dir, corpusLockFileName)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil && !exclusive {
+		f, err = os.Open(lockPath)
+		if err != nil {
+			log.Debug().Msgf("Not locking read-only corpus directory %s: %v", dir, err)
+			return func() {}, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening corpus lock file: %w", err)
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		if err := unlockFile(f); err != nil {
+			log.Warn().Msgf("error unlocking %s: %v", lockPath, err)
+		}
+		f.Close()
+	}, nil
+}
+
+// writeFileAtomic replaces filePath with data, so that readers see either the
+// old or the new content. The data is synced to disk before the rename.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpPath, err := writeTempFile(filePath, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(filePath))
+}
+
+// writeTempFile writes data to a temporary file next to filePath, synced to
+// disk, and returns its path.
+func writeTempFile(filePath string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+	err = func() error {
+		if _, err := tmp.Write(data); err != nil {
+			return err
+		}
+		if err := tmp.Chmod(0644); err != nil {
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			return err
+		}
+		return tmp.Close()
+	}()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// syncDir persists renames in dir.
+func syncDir(dir string) error {
+	if d, err := os.Open(dir); err == nil {
+		defer d.Close()
+		return d.Sync()
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func newManifestEntry(c *CorpusFile) ManifestEntry {
+	return ManifestEntry{
+		Project:     c.Project,
+		Version:     c.Version,
+		Revision:    c.Revision,
+		BuiltAt:     c.BuiltAt,
+		ContentHash: c.ContentHash,
+		Calls:       len(c.Calls),
+		Provenance:  c.Provenance,
+		File:        filepath.Base(c.GetPath()),
+		Dir:         CorpusDir,
+	}
+}
+
+// ReadManifest merges the manifests of all corpus directories. All versions of
+// a project are taken from the directory with the highest precedence that has
+// the project. Directories of CorpusPath that can't be read are skipped with a warning.
+func ReadManifest() (*CorpusManifest, error) {
+	return readMergedManifest(true)
+}
+
+// readMergedManifest merges the manifests of all corpus directories, see
+// ReadManifest. Each manifest is read under the shared lock of its directory
+// if lock is set. Otherwise the caller must hold the locks, see lockLayers.
+func readMergedManifest(lock bool) (*CorpusManifest, error) {
+	if CorpusDir == "" {
+		return nil, fmt.Errorf("corpus directory not set")
+	}
+	merged := &CorpusManifest{FormatVersion: CorpusFormatVersion, Entries: []ManifestEntry{}}
+	// Project ==> corpus directory it is taken from
+	owners := make(map[string]string)
+	layers := CorpusLayers()
+	for i := len(layers) - 1; i >= 0; i-- {
+		dir := layers[i]
+		manifest, err := readLayerManifest(dir, lock)
+		if err != nil {
+			if dir == CorpusDir {
+				return nil, err
+			}
+			log.Warn().Msgf("Skipping corpus directory %s: %v", dir, err)
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			if owner, ok := owners[entry.Project]; ok && owner != dir {
+				log.Debug().Msgf("Project %s of %s is overridden by %s", entry.Project, dir, owner)
+				continue
+			}
+			owners[entry.Project] = dir
+			merged.Entries = append(merged.Entries, entry)
+		}
+	}
+	merged.sortEntries()
+	return merged, nil
+}
+
+func readLayerManifest(dir string, lock bool) (*CorpusManifest, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	if lock {
+		unlock, err := lockDir(dir, false)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	return readManifestAt(dir)
+}
+
+// lockLayers takes the shared locks of all corpus directories and returns a
+// function releasing them.
+func lockLayers() (func(), error) {
+	unlocks := []func(){}
+	unlockAll := func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+	for _, dir := range CorpusLayers() {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		unlock, err := lockDir(dir, false)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// readManifest reads the manifest of CorpusDir without locking it.
+func readManifest() (*CorpusManifest, error) {
+	return readManifestAt(CorpusDir)
+}
+
+// readManifestAt reads the manifest of a corpus directory without locking it.
+// A missing manifest is an empty one.
+func readManifestAt(dir string) (*CorpusManifest, error) {
+	manifest := &CorpusManifest{FormatVersion: CorpusFormatVersion, Entries: []ManifestEntry{}}
+	data, err := os.ReadFile(manifestPath(dir))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading corpus manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("error unmarshalling corpus manifest %s: %w", manifestPath(dir), err)
+	}
+	if manifest.FormatVersion > CorpusFormatVersion {
+		return nil, fmt.Errorf("corpus manifest %s has format version %d, but this build of logalign only supports up to %d",
+			manifestPath(dir), manifest.FormatVersion, CorpusFormatVersion)
+	}
+	for i := range manifest.Entries {
+		manifest.Entries[i].Dir = dir
+	}
+	return manifest, nil
+}
+
+// save writes the manifest read by readManifest to CorpusDir, ordering its entries by project and
+// build time. The caller must hold the exclusive lock of CorpusDir.
+func (m *CorpusManifest) save() error {
+	m.sortEntries()
+	m.FormatVersion = CorpusFormatVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling corpus manifest: %w", err)
+	}
+	if err := writeFileAtomic(manifestPath(CorpusDir), data); err != nil {
+		return fmt.Errorf("error writing corpus manifest: %w", err)
+	}
+	return nil
+}
+
+func (m *CorpusManifest) sortEntries() {
+	slices.SortFunc(m.Entries, func(a, b ManifestEntry) int {
+		if c := strings.Compare(a.Project, b.Project); c != 0 {
+			return c
+		}
+		if c := a.BuiltAt.Compare(b.BuiltAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Version, b.Version)
+	})
+}
+
+// put adds or replaces the entry of the same project and version.
+func (m *CorpusManifest) put(entry ManifestEntry) {
+	m.Entries = slices.DeleteFunc(m.Entries, func(e ManifestEntry) bool {
+		return e.Project == entry.Project && e.Version == entry.Version
+	})
+	m.Entries = append(m.Entries, entry)
+}
+
+// Projects returns the names of all projects in the manifest.
+func (m *CorpusManifest) Projects() []string {
+	projects := []string{}
+	for _, entry := range m.Entries {
+		if !slices.Contains(projects, entry.Project) {
+			projects = append(projects, entry.Project)
+		}
+	}
+	return projects
+}
+
+// Load reads the corpus files of the given projects, or of all projects if
+// projects is empty. Projects missing from the manifest are ignored. Corpus
+// files that are corrupted or fail to be read are skipped with a warning.
+//
+// The manifests are read again under the same locks as the corpus files, and
+// replace the entries of m, so that a build finishing after m was read can't
+// swap the corpus files it lists.
+func (m *CorpusManifest) Load(projects []string) (Corpus, error) {
+	unlock, err := lockLayers()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	current, err := readMergedManifest(false)
+	if err != nil {
+		return nil, err
+	}
+	m.Entries = current.Entries
+	corpus := NewCorpus()
+	for _, entry := range m.Entries {
+		if len(projects) > 0 && !slices.Contains(projects, entry.Project) {
+			continue
+		}
+		corpusFile, err := readCorpusFile(entry.Path(), entry.Checksum)
+		if err != nil {
+			log.Warn().Msgf("Skipping version %q of project %s: %v", entry.Version, entry.Project, err)
+			continue
+		}
+		corpus.AddCorpusFile(corpusFile)
+	}
+	return corpus, nil
+}
+
+// saveCorpusFiles atomically writes corpus files to CorpusDir and adds them to
+// the manifest. Either all of them are saved or none.
+func saveCorpusFiles(files []*CorpusFile) error {
+	// The manifest is read again under the lock, to keep entries saved by concurrent builds
+	return updateManifest(func(m *CorpusManifest) error {
+		for _, c := range files {
+			entry, err := m.stageCorpusFile(c)
+			if err != nil {
+				return err
+			}
+			m.put(entry)
+		}
+		return nil
+	})
+}
+
+// SaveCorpusFiles atomically writes the corpus files of a build to CorpusDir
+// and adds them to the manifest. Either all of them are saved or none.
+func SaveCorpusFiles(files []CorpusFile) error {
+	ptrs := []*CorpusFile{}
+	for i := range files {
+		log.Info().Msgf("Saving corpus file for project %s", files[i].Project)
+		ptrs = append(ptrs, &files[i])
+	}
+	return saveCorpusFiles(ptrs)
+}
+
+// stageCorpusFile writes a corpus file next to its path in CorpusDir, and
+// returns its manifest entry. It replaces the live file when updateManifest
+// saves m. The caller must hold the exclusive lock of CorpusDir.
+func (m *CorpusManifest) stageCorpusFile(c *CorpusFile) (ManifestEntry, error) {
+	buf := bytes.Buffer{}
+	if err := writeCorpusFile(&buf, c); err != nil {
+		return ManifestEntry{}, fmt.Errorf("error encoding corpus file: %w", err)
+	}
+	filePath, err := corpusFilePath(c)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := m.stageFile(filePath, buf.Bytes()); err != nil {
+		return ManifestEntry{}, err
+	}
+	entry := newManifestEntry(c)
+	entry.Checksum = checksum(buf.Bytes())
+	return entry, nil
+}
+
+// stageFile writes data next to filePath, to replace it when updateManifest saves m.
+func (m *CorpusManifest) stageFile(filePath string, data []byte) error {
+	tmpPath, err := writeTempFile(filePath, data)
+	if err != nil {
+		return fmt.Errorf("error writing corpus file: %w", err)
+	}
+	m.stagedFiles = append(m.stagedFiles, stagedFile{tmpPath: tmpPath, path: filePath})
+	return nil
+}
+
+// discardStagedFiles removes the staged files that weren't moved into place.
+func (m *CorpusManifest) discardStagedFiles() {
+	for _, staged := range m.stagedFiles {
+		os.Remove(staged.tmpPath)
+	}
+	m.stagedFiles = nil
+}
+
+// commitStagedFiles moves the staged files to their corpus file paths.
+func (m *CorpusManifest) commitStagedFiles() error {
+	for len(m.stagedFiles) > 0 {
+		staged := m.stagedFiles[0]
+		if err := os.Rename(staged.tmpPath, staged.path); err != nil {
+			return fmt.Errorf("error writing corpus file %q: %w", staged.path, err)
+		}
+		m.stagedFiles = m.stagedFiles[1:]
+	}
+	return syncDir(CorpusDir)
+}
+
+func writeCorpusFile(w io.Writer, c *CorpusFile) error {
+	if _, err := fmt.Fprintf(w, "%s %d\n", corpusFileMagic, CorpusFormatVersion); err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(c); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readCorpusFile reads and decodes a corpus file, after checking that its
+// SHA-256 is expectedChecksum if set.
+func readCorpusFile(filePath string, expectedChecksum string) (*CorpusFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading corpus file %q: %w", filePath, err)
+	}
+	if expectedChecksum != "" && checksum(data) != expectedChecksum {
+		return nil, fmt.Errorf("checksum mismatch of corpus file %q. It may be corrupted", filePath)
+	}
+	return decodeCorpusFile(data, filePath)
+}
+
+// decodeCorpusFile decodes the content of the corpus file at filePath.
+func decodeCorpusFile(data []byte, filePath string) (*CorpusFile, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("error reading header of corpus file %q: %w", filePath, err)
+	}
+	var version int
+	if _, err := fmt.Sscanf(header, corpusFileMagic+" %d\n", &version); err != nil {
+		return nil, fmt.Errorf("%q is not a corpus file", filePath)
+	}
+	if version > CorpusFormatVersion {
+		return nil, fmt.Errorf("corpus file %q has format version %d, but this build of logalign only supports up to %d",
+			filePath, version, CorpusFormatVersion)
+	}
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing corpus file %q: %w", filePath, err)
+	}
+	defer gz.Close()
+	var corpusFile CorpusFile
+	if err := json.NewDecoder(gz).Decode(&corpusFile); err != nil {
+		return nil, fmt.Errorf("error unmarshalling corpus file %q: %w", filePath, err)
+	}
+	// Reading to the end verifies the gzip checksum, catching truncated files
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, fmt.Errorf("error decompressing corpus file %q: %w", filePath, err)
+	}
+	return &corpusFile, nil
+}
+
+// ReadCorpus reads the corpus files of the given projects, or of all projects
+// if projects is empty, through the manifest of CorpusDir.
+func ReadCorpus(projects []string) (Corpus, error) {
+	log.Info().Msgf("Reading corpus from %q", CorpusLayers())
+	manifest := &CorpusManifest{}
+	return manifest.Load(projects)
+}
+
+// LegacyCorpusFiles returns the corpus files of earlier releases in CorpusDir,
+// which are ignored until migrated.
+func LegacyCorpusFiles() ([]string, error) {
+	return filepath.Glob(filepath.Join(CorpusDir, CorpusFilePrefix+"*"+legacyCorpusFileSuffix))
+}
+
+// MigrateCorpus converts the corpus files of earlier releases in CorpusDir to
+// the current format, adds them to the manifest and removes the old files.
+// It returns the number of migrated files.
+func MigrateCorpus() (int, error) {
+	legacyFiles, err := LegacyCorpusFiles()
+	if err != nil {
+		return 0, err
+	}
+	for i, filePath := range legacyFiles {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return i, fmt.Errorf("error reading corpus file %q: %w", filePath, err)
+		}
+		var corpusFile CorpusFile
+		if err := json.Unmarshal(data, &corpusFile); err != nil {
+			return i, fmt.Errorf("error unmarshalling corpus file %q: %w", filePath, err)
+		}
+		if corpusFile.ContentHash == "" {
+			if corpusFile.ContentHash, err = corpusFile.ComputeContentHash(); err != nil {
+				return i, fmt.Errorf("error hashing corpus file %q: %w", filePath, err)
+			}
+		}
+		if err := corpusFile.Save(); err != nil {
+			return i, err
+		}
+		if err := os.Remove(filePath); err != nil {
+			return i, fmt.Errorf("error removing migrated corpus file %q: %w", filePath, err)
+		}
+		log.Info().Msgf("Migrated %s to %s", filePath, corpusFile.GetPath())
+	}
+	return len(legacyFiles), nil
+}
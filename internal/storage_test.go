@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useCorpusDir points CorpusDir to an empty temporary directory, without
+// other corpus layers, for the duration of the test.
+func useCorpusDir(t *testing.T) string {
+	t.Helper()
+	oldDir, oldPath := CorpusDir, CorpusPath
+	t.Cleanup(func() { CorpusDir, CorpusPath = oldDir, oldPath })
+	CorpusDir, CorpusPath = t.TempDir(), nil
+	return CorpusDir
+}
+
+func testCorpusFile(project string, version string, builtAt time.Time, formats ...string) *CorpusFile {
+	c := &CorpusFile{Project: project, Version: version, BuiltAt: builtAt.UTC()}
+	for i, format := range formats {
+		c.Calls = append(c.Calls, LogCall{Project: project, File: "main.go", Line: i + 1, FormatString: format})
+	}
+	return c
+}
+
+func encodeCorpusFile(t *testing.T, c *CorpusFile) []byte {
+	t.Helper()
+	buf := bytes.Buffer{}
+	if err := writeCorpusFile(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeCorpusFile(t *testing.T) {
+	c := testCorpusFile("app", "v1", time.Unix(1700000000, 0), "started", "stopped %d")
+	encoded := encodeCorpusFile(t, c)
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("{}"))
+	gz.Close()
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"round trip", encoded, ""},
+		{"older format version", append([]byte(corpusFileMagic+" 0\n"), gzipped.Bytes()...), ""},
+		{"no header", nil, "error reading header"},
+		{"legacy JSON", []byte("{\"project\": \"app\"}\n"), "is not a corpus file"},
+		{"newer format version", []byte(fmt.Sprintf("%s %d\n", corpusFileMagic, CorpusFormatVersion+1)), "has format version"},
+		{"not compressed", []byte(corpusFileMagic + " 1\n{}"), "error decompressing"},
+		{"truncated", encoded[:len(encoded)-4], "error decompressing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := decodeCorpusFile(tt.data, "test.corpus")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeCorpusFile error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeCorpusFile: %v", err)
+			}
+			if tt.name == "round trip" {
+				want, _ := json.Marshal(c)
+				got, _ := json.Marshal(decoded)
+				if !bytes.Equal(got, want) {
+					t.Errorf("decoded %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveAndLoadCorpus(t *testing.T) {
+	useCorpusDir(t)
+	builtAt := time.Unix(1700000000, 0)
+	files := []CorpusFile{
+		*testCorpusFile("app", "v1", builtAt, "started"),
+		*testCorpusFile("app", "v2", builtAt.Add(time.Hour), "started", "stopped"),
+		*testCorpusFile("lib", "", builtAt, "loaded"),
+	}
+	if err := SaveCorpusFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range manifest.Entries {
+		got = append(got, fmt.Sprintf("%s@%s:%d", entry.Project, entry.Version, entry.Calls))
+		if entry.Checksum == "" {
+			t.Errorf("entry %s@%s has no checksum", entry.Project, entry.Version)
+		}
+	}
+	if want := "[app@v1:1 app@v2:2 lib@:1]"; fmt.Sprint(got) != want {
+		t.Errorf("manifest entries = %v, want %s", got, want)
+	}
+
+	corpus, err := ReadCorpus([]string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := corpus["lib"]; ok {
+		t.Errorf("ReadCorpus([app]) read project lib")
+	}
+	if file, _, err := corpus.SelectVersion("app", nil); err != nil || file.Version != "v2" {
+		t.Errorf("latest build of app = %v, %v, want v2", file, err)
+	}
+}
+
+func TestMigrateCorpus(t *testing.T) {
+	dir := useCorpusDir(t)
+	legacy := testCorpusFile("app", "", time.Unix(1700000000, 0), "started")
+	data, err := json.MarshalIndent(legacy, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyPath := filepath.Join(dir, CorpusFilePrefix+"app"+legacyCorpusFileSuffix)
+	if err := os.WriteFile(legacyPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := LegacyCorpusFiles(); err != nil || len(files) != 1 {
+		t.Fatalf("LegacyCorpusFiles = %v, %v, want the legacy file", files, err)
+	}
+	migrated, err := MigrateCorpus()
+	if err != nil || migrated != 1 {
+		t.Fatalf("MigrateCorpus = %d, %v, want 1", migrated, err)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Errorf("legacy file still exists: %v", err)
+	}
+	corpus, err := ReadCorpus(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, _, err := corpus.SelectVersion("app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Calls) != 1 || file.ContentHash == "" {
+		t.Errorf("migrated corpus file has %d calls and content hash %q, want 1 call and a hash", len(file.Calls), file.ContentHash)
+	}
+	if migrated, err := MigrateCorpus(); err != nil || migrated != 0 {
+		t.Errorf("second MigrateCorpus = %d, %v, want 0", migrated, err)
+	}
+}
+
+func TestCorpusFileChecksum(t *testing.T) {
+	useCorpusDir(t)
+	if err := testCorpusFile("app", "", time.Unix(1700000000, 0), "started").Save(); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := manifest.Entries[0]
+	if _, err := readCorpusFile(entry.Path(), entry.Checksum); err != nil {
+		t.Fatalf("readCorpusFile: %v", err)
+	}
+
+	data, err := os.ReadFile(entry.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a bit of the gzip header, which decodes the same
+	data[len(corpusFileMagic)+7] ^= 1
+	if err := os.WriteFile(entry.Path(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCorpusFile(entry.Path(), entry.Checksum); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("readCorpusFile of a corrupted file = %v, want checksum mismatch", err)
+	}
+	if _, err := readCorpusFile(entry.Path(), ""); err != nil {
+		t.Errorf("readCorpusFile without checksum: %v", err)
+	}
+	corpus, err := ReadCorpus(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := corpus["app"]; ok {
+		t.Errorf("ReadCorpus loaded a corrupted corpus file")
+	}
+}
+
+func TestSaveCorpusFilesAllOrNone(t *testing.T) {
+	dir := useCorpusDir(t)
+	builtAt := time.Unix(1700000000, 0)
+	if err := testCorpusFile("app", "v1", builtAt, "started").Save(); err != nil {
+		t.Fatal(err)
+	}
+	err := SaveCorpusFiles([]CorpusFile{
+		*testCorpusFile("app", "v2", builtAt, "started"),
+		*testCorpusFile("../lib", "", builtAt, "loaded"),
+	})
+	if err == nil {
+		t.Fatal("SaveCorpusFiles with an invalid project succeeded, want error")
+	}
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Entries) != 1 || manifest.Entries[0].Version != "v1" {
+		t.Errorf("manifest entries = %+v, want only app@v1", manifest.Entries)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := fmt.Sprint([]string{corpusLockFileName, CorpusFilePrefix + "app@v1" + CorpusFileSuffix, CorpusManifestFileName})
+	if fmt.Sprint(names) != want {
+		t.Errorf("files of the corpus directory = %v, want %s", names, want)
+	}
+}
+
+func TestConcurrentSaves(t *testing.T) {
+	useCorpusDir(t)
+	const projects = 8
+	errs := make(chan error, projects)
+	for i := 0; i < projects; i++ {
+		go func() {
+			errs <- testCorpusFile(fmt.Sprintf("project%d", i), "", time.Unix(1700000000, 0), "started").Save()
+		}()
+	}
+	for i := 0; i < projects; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Projects()) != projects {
+		t.Errorf("manifest has projects %v, want %d projects", manifest.Projects(), projects)
+	}
+}
+
+func TestCorpusLayers(t *testing.T) {
+	personal := useCorpusDir(t)
+	CorpusPath = []string{"/etc/logalign/corpus", "", "/srv/team", personal, "/srv/team"}
+	want := []string{"/etc/logalign/corpus", "/srv/team", personal}
+	if got := CorpusLayers(); !slices.Equal(got, want) {
+		t.Errorf("CorpusLayers = %q, want %q", got, want)
+	}
+}
+
+func TestLayeredManifest(t *testing.T) {
+	personal := useCorpusDir(t)
+	system, shared := t.TempDir(), t.TempDir()
+	builtAt := time.Unix(1700000000, 0)
+	// Builds of each layer, saved through CorpusDir
+	layers := []struct {
+		dir   string
+		files []*CorpusFile
+	}{
+		{system, []*CorpusFile{
+			testCorpusFile("app", "v1", builtAt, "system app"),
+			testCorpusFile("libc", "", builtAt, "system libc"),
+		}},
+		{shared, []*CorpusFile{
+			testCorpusFile("app", "v2", builtAt.Add(time.Hour), "shared app"),
+			testCorpusFile("db", "", builtAt, "shared db"),
+		}},
+		{personal, []*CorpusFile{
+			testCorpusFile("db", "dev", builtAt, "personal db"),
+		}},
+	}
+	for _, layer := range layers {
+		CorpusDir = layer.dir
+		for _, c := range layer.files {
+			if err := c.Save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	CorpusDir = personal
+	CorpusPath = []string{system, filepath.Join(system, "missing"), shared}
+
+	manifest, err := ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range manifest.Entries {
+		got = append(got, fmt.Sprintf("%s@%s:%s", entry.Project, entry.Version, entry.Dir))
+	}
+	// All versions of a project come from the layer with the highest precedence having it
+	want := []string{"app@v2:" + shared, "db@dev:" + personal, "libc@:" + system}
+	if !slices.Equal(got, want) {
+		t.Errorf("merged manifest = %q, want %q", got, want)
+	}
+
+	corpus, err := ReadCorpus(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for project, format := range map[string]string{"app": "shared app", "db": "personal db", "libc": "system libc"} {
+		file, _, err := corpus.SelectVersion(project, nil)
+		if err != nil {
+			t.Errorf("project %s: %v", project, err)
+		} else if file.Calls[0].FormatString != format {
+			t.Errorf("project %s read from %q, want %q", project, file.Calls[0].FormatString, format)
+		}
+	}
+
+	// Only CorpusDir is written to
+	if _, err := RemoveProject("app", "", true); err == nil {
+		t.Errorf("RemoveProject of a project of another layer succeeded, want error")
+	}
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// TryQueryResult is the outcome of running a single log call definition
+// against a source file.
+type TryQueryResult struct {
+	File        string
+	SyntaxError *Diagnostic
+	Matches     []QueryMatch
+	// Set if the file could not be read or parsed
+	Err error
+}
+
+// loadLocalWorkspaceConfigs reads the definition files of the working directory of repoRoot.
+func loadLocalWorkspaceConfigs(repoRoot string) ([]*subtreeConfig, error) {
+	sourceTree, err := OpenSourceTree(repoRoot, "")
+	if err != nil {
+		return nil, fmt.Errorf("error opening source tree: %w", err)
+	}
+	defer sourceTree.Close()
+	allFiles, err := sourceTree.ListFiles()
+	if err != nil {
+		return nil, fmt.Errorf("error collecting source files: %w", err)
+	}
+	return loadWorkspaceConfigs(sourceTree, allFiles)
+}
+
+// WorkspaceConfigFiles returns the paths of the definition files of repoRoot,
+// including nested ones.
+func WorkspaceConfigFiles(repoRoot string) ([]string, error) {
+	configs, err := loadLocalWorkspaceConfigs(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSubtreeConfigs(configs)
+	files := []string{}
+	for _, cfg := range configs {
+		files = append(files, filepath.Join(repoRoot, filepath.FromSlash(cfg.Root), LogCallDefinitionFileName))
+	}
+	return files, nil
+}
+
+// LoadLogCallDefinition returns the compiled definition with the given ID
+// from the definition files of repoRoot, including nested ones.
+func LoadLogCallDefinition(repoRoot string, id string) (*LogCallDefinition, error) {
+	configs, err := loadLocalWorkspaceConfigs(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSubtreeConfigs(configs)
+	for _, cfg := range configs {
+		for _, def := range cfg.Definitions {
+			if def.ID != id {
+				continue
+			}
+			def.CompiledQuery = nil
+			if err := def.Compile(); err != nil {
+				return nil, err
+			}
+			return &def, nil
+		}
+	}
+	return nil, fmt.Errorf("log call definition %s not found in %s", id, repoRoot)
+}
+
+// TryQuery runs def against each of the files, keeping every match along with
+// the reason it would be dropped from a corpus.
+func TryQuery(ctx context.Context, def *LogCallDefinition, files []string) []TryQueryResult {
+	parser := sitter.NewParser()
+	defer parser.Close()
+	results := make([]TryQueryResult, 0, len(files))
+	for _, filePath := range files {
+		result := TryQueryResult{File: filePath}
+		source, err := os.ReadFile(filePath)
+		if err != nil {
+			result.Err = fmt.Errorf("error reading file %q: %w", filePath, err)
+			results = append(results, result)
+			continue
+		}
+		tree, langDef, err := parseSource(ctx, parser, filePath, source)
+		if err != nil {
+			result.Err = err
+		} else if langDef == nil {
+			result.Err = fmt.Errorf("language definition for file %s not found", filePath)
+		} else if !strings.EqualFold(def.Language, langDef.Name) {
+			result.Err = fmt.Errorf("file %s is written in %s, but the definition is for %s", filePath, langDef.Name, def.Language)
+			tree.Close()
+		} else {
+			result.SyntaxError = syntaxErrorDiagnostic(tree, "", filePath)
+			result.Matches = runDefinitionQuery(def, tree, source, LogCall{File: filePath})
+			tree.Close()
+		}
+		results = append(results, result)
+	}
+	return results
+}
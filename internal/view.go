@@ -1,14 +1,18 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
 	"regexp/syntax"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	hs "github.com/flier/gohs/hyperscan"
 	pcre2 "github.com/htfy96/go-pcre2/v2"
@@ -21,6 +25,22 @@ type ViewConfig struct {
 	MinMatchChars     int
 	MinMatchWordChars int
 	MinMatchedRatio   float64
+	// Name of the preset of the prefix of log lines, LinePrefixAuto to detect it, or
+	// LinePrefixNone / empty to skip StartPos or StartCharPos instead
+	LinePrefix string
+	// Regex splitting log lines into the logged message, captured by the named group
+	// "msg", and fields captured by the other named groups, e.g. time, level and
+	// suffix. Only the message is matched
+	EnvelopeRegex string
+	// Envelope field ==> regex. Only output lines whose fields match all of them
+	FieldFilters map[string]string
+	// Envelope fields output in place of the text around the message
+	ShowFields []string
+	// Preset of SourceLocationPresets, or a regex with the named groups "file" and
+	// "line", extracting the source location logged in each line. Matches at that
+	// location are preferred, and other matches are flagged. The file and line
+	// fields of the envelope are used if empty
+	SourceLocation string
 	// 1-indexed startPos of match for a log line
 	StartPos int
 	// A single character followed by a position index (1-indexed) to start matching log lines after a specific character
@@ -28,6 +48,59 @@ type ViewConfig struct {
 	SourceColumnWidth     int
 	SkipPrintArgumentExpr bool
 	ProjectFilter         []string
+	// Project ==> version to match log lines against. The entry of the empty
+	// project applies to all projects. Other projects use their latest build.
+	Versions map[string]string
+	// Switch to another version of a project whenever a log line matches its version_marker
+	DetectVersion bool
+	// Append the ID, level, logger and enclosing function of the matched call to each line
+	ShowCallInfo bool
+	// Only output lines matched to calls with one of these levels
+	LevelFilter []string
+	// Only output lines matched to calls whose logger / enclosing function matches the regex
+	LoggerFilter   string
+	FunctionFilter string
+	// Number of source lines around the matched call to print under each line. Needs
+	// a corpus built with snippets
+	Context int
+	// Append the last commit that changed the matched call to each line. Needs a corpus built with blame
+	ShowBlame bool
+	// Warn about corpus files built longer ago than this. Disabled if 0
+	MaxCorpusAge time.Duration
+	// Project ==> local checkout of its repo that editor links point to. The entry of
+	// the empty project applies to all projects. Defaults to the repo the corpus was built from
+	SourceRoots map[string]string
+	// Editor opening editor links, one of EditorNames()
+	Editor string
+	// Link targets of matched lines: LinkTargetWeb, LinkTargetEditor or the name of a
+	// link of the definition. The first one links the source column, and the others
+	// are appended to the line. Defaults to the editor link if Editor is set, then the web link
+	Links []string
+}
+
+// ErrFilteredOut is returned by ProcessLine for lines rejected by the call filters of the config.
+var ErrFilteredOut = errors.New("line filtered out")
+
+// HasCallFilter reports whether lines are filtered by the attributes of their matched calls.
+func (vc ViewConfig) HasCallFilter() bool {
+	return len(vc.LevelFilter) > 0 || vc.LoggerFilter != "" || vc.FunctionFilter != ""
+}
+
+// linkTargets returns Links or its default.
+func (vc ViewConfig) linkTargets() []string {
+	if len(vc.Links) > 0 {
+		return vc.Links
+	}
+	if vc.Editor != "" {
+		return []string{LinkTargetEditor, LinkTargetWeb}
+	}
+	return []string{LinkTargetWeb}
+}
+
+// hasLinePosition reports whether a line prefix preset, StartPos or StartCharPos is set.
+func (vc ViewConfig) hasLinePosition() bool {
+	return (vc.LinePrefix != "" && vc.LinePrefix != LinePrefixNone && vc.LinePrefix != LinePrefixAuto) ||
+		vc.StartPos > 1 || len(vc.StartCharPos) > 0
 }
 
 func (vc ViewConfig) MustGetStartCharPos() (byte, int) {
@@ -48,6 +121,37 @@ func (vc ViewConfig) Validate() error {
 	if vc.SourceColumnWidth < 0 {
 		return fmt.Errorf("source_column_width must be non-negative")
 	}
+	if vc.Context < 0 {
+		return fmt.Errorf("context must be non-negative")
+	}
+	if vc.EnvelopeRegex != "" {
+		if _, err := CompileLineEnvelope(vc.EnvelopeRegex); err != nil {
+			return err
+		}
+		if vc.hasLinePosition() {
+			return fmt.Errorf("cannot use envelope_regex together with line_prefix, start_pos or start_char_pos")
+		}
+	}
+	if vc.SourceLocation != "" {
+		if _, err := CompileSourceLocation(vc.SourceLocation); err != nil {
+			return err
+		}
+	}
+	for name, filter := range vc.FieldFilters {
+		if _, err := regexp.Compile(filter); err != nil {
+			return fmt.Errorf("invalid filter of field %s: %w", name, err)
+		}
+	}
+	if vc.LinePrefix != "" && vc.LinePrefix != LinePrefixNone {
+		if vc.LinePrefix != LinePrefixAuto {
+			if _, err := GetLinePrefixPreset(vc.LinePrefix); err != nil {
+				return err
+			}
+		}
+		if len(vc.StartCharPos) > 0 || vc.StartPos > 1 {
+			return fmt.Errorf("cannot use line_prefix together with start_pos or start_char_pos")
+		}
+	}
 	if len(vc.StartCharPos) > 0 && vc.StartPos > 1 {
 		return fmt.Errorf("cannot use both start_pos and start_char_pos together")
 	}
@@ -61,15 +165,91 @@ func (vc ViewConfig) Validate() error {
 			return fmt.Errorf("start_char_pos: posIdx must be a positive integer")
 		}
 	}
+	for _, level := range vc.LevelFilter {
+		if !slices.Contains(AllLogLevels, level) {
+			return fmt.Errorf("invalid level %q. Valid levels: %q", level, AllLogLevels)
+		}
+	}
+	if _, err := regexp.Compile(vc.LoggerFilter); err != nil {
+		return fmt.Errorf("invalid logger filter: %w", err)
+	}
+	if _, err := regexp.Compile(vc.FunctionFilter); err != nil {
+		return fmt.Errorf("invalid function filter: %w", err)
+	}
+	if _, ok := editorLinks[vc.Editor]; !ok && vc.Editor != "" {
+		return fmt.Errorf("invalid editor %q. Valid editors: %q", vc.Editor, EditorNames())
+	}
+	if slices.Contains(vc.Links, LinkTargetEditor) && vc.Editor == "" {
+		return fmt.Errorf("editor links need an editor")
+	}
 
 	return nil
 }
 
+// ApplyProfile sets the settings of a [view] profile that are not set
+// explicitly, as reported by isSet given the name of the setting. The line
+// prefix, start position and envelope of the profile only apply if none of
+// them is set explicitly.
+func (vc *ViewConfig) ApplyProfile(profile *ViewProfile, isSet func(setting string) bool) {
+	if profile == nil {
+		return
+	}
+	if !isSet("line_prefix") && !isSet("start_pos") && !isSet("start_char_pos") && !isSet("envelope_regex") {
+		if profile.LinePrefix != "" {
+			vc.LinePrefix = profile.LinePrefix
+		} else if profile.StartPos > 1 || profile.StartCharPos != "" {
+			vc.LinePrefix = LinePrefixNone
+		}
+		if profile.StartPos > 1 {
+			vc.StartPos = profile.StartPos
+		}
+		if profile.StartCharPos != "" {
+			vc.StartCharPos = profile.StartCharPos
+		}
+		if profile.EnvelopeRegex != "" {
+			vc.EnvelopeRegex = profile.EnvelopeRegex
+		}
+	}
+	if profile.SourceLocation != "" && !isSet("source_location") {
+		vc.SourceLocation = profile.SourceLocation
+	}
+	if profile.MinMatchChars != nil && !isSet("min_match_chars") {
+		vc.MinMatchChars = *profile.MinMatchChars
+	}
+	if profile.MinMatchWordChars != nil && !isSet("min_match_word_chars") {
+		vc.MinMatchWordChars = *profile.MinMatchWordChars
+	}
+	if profile.MinMatchedRatio != nil && !isSet("min_matched_ratio") {
+		vc.MinMatchedRatio = *profile.MinMatchedRatio
+	}
+	if profile.SourceColumnWidth != nil && !isSet("source_column_width") {
+		vc.SourceColumnWidth = *profile.SourceColumnWidth
+	}
+}
+
+// Validate checks the settings of the profile like ViewConfig.Validate.
+func (p ViewProfile) Validate() error {
+	vc := ViewConfig{}
+	vc.ApplyProfile(&p, func(string) bool { return false })
+	return vc.Validate()
+}
+
 type LogCallRef struct {
 	Project   string
+	Version   string
 	CallIndex int
 }
 
+type DefinitionRef struct {
+	Project string
+	Version string
+	ID      string
+}
+
+// VersionSelection is a map of project names to the version of their corpus
+// that log lines are matched against.
+type VersionSelection map[string]string
+
 type Viewer struct {
 	Config ViewConfig
 	Corpus Corpus
@@ -77,7 +257,25 @@ type Viewer struct {
 	CompiledRegex                    map[LogCallRef]*pcre2.Regexp
 	CompiledAllRegex                 hs.BlockDatabase
 	CompiledAllPatternIDToLogCallMap map[int]LogCallRef
-	DefinitionIDToDefinitionMap      map[string]*LogCallDefinition
+	DefinitionMap                    map[DefinitionRef]*LogCallDefinition
+	// Versions in effect before any version marker is seen
+	DefaultVersions VersionSelection
+	// Project ==> compiled version_marker. Only populated if Config.DetectVersion is set
+	VersionMarkers map[string]*regexp.Regexp
+	// Where CompiledAllRegex is cached
+	HSDBCachePath string
+	// Prefix of log lines skipped by matching. Set by DetectLinePrefix if
+	// Config.LinePrefix is LinePrefixAuto. StartPos and StartCharPos apply if nil
+	LinePrefix *LinePrefix
+	// Splits log lines into the message and its fields. Takes precedence over LinePrefix
+	Envelope *LineEnvelope
+
+	sourceLocation *SourceLocationFormat
+	loggerFilter   *regexp.Regexp
+	fieldFilters   map[string]*regexp.Regexp
+	functionFilter *regexp.Regexp
+	// Definition ==> link name ==> template. LinkTargetWeb is the link_template
+	linkTemplates map[DefinitionRef]map[string]*LinkTemplate
 }
 
 func getRegexGroupName(lcRef LogCallRef) string {
@@ -85,21 +283,26 @@ func getRegexGroupName(lcRef LogCallRef) string {
 }
 
 func (v *Viewer) getLogCallFromRef(lcRef LogCallRef) *LogCall {
-	calls := v.Corpus[lcRef.Project].Calls
+	calls := v.Corpus[lcRef.Project][lcRef.Version].Calls
 	return &calls[lcRef.CallIndex]
 }
 
-func buildOrLoadCachedHSPatternsDB(patterns []*hs.Pattern) (hs.BlockDatabase, error) {
-	cacheDir := viper.GetString("cache_dir")
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create cache directory: %w", err)
-	}
+// hsDBCachePath returns the path of the cached Hyperscan database of patterns.
+func hsDBCachePath(patterns []*hs.Pattern) string {
 	hash := fnv.New64()
 	hash.Write([]byte("HSPATV1"))
 	for _, pattern := range patterns {
 		hash.Write([]byte(pattern.Expression))
 	}
-	cachePath := filepath.Join(cacheDir, fmt.Sprintf("%x.hsdb", hash.Sum64()))
+	return filepath.Join(viper.GetString("cache_dir"), fmt.Sprintf("%x.hsdb", hash.Sum64()))
+}
+
+func buildOrLoadCachedHSPatternsDB(patterns []*hs.Pattern) (hs.BlockDatabase, error) {
+	cacheDir := viper.GetString("cache_dir")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	cachePath := hsDBCachePath(patterns)
 	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
 		db, err := hs.NewBlockDatabase(patterns...)
 		if err != nil {
@@ -129,81 +332,220 @@ func buildOrLoadCachedHSPatternsDB(patterns []*hs.Pattern) (hs.BlockDatabase, er
 }
 
 func NewViewer(config ViewConfig, corpus Corpus) (*Viewer, error) {
-	compiledRegex := make(map[LogCallRef]*pcre2.Regexp, 0)
-
+	v := &Viewer{
+		Config:                           config,
+		Corpus:                           corpus,
+		CompiledRegex:                    make(map[LogCallRef]*pcre2.Regexp, 0),
+		CompiledAllPatternIDToLogCallMap: make(map[int]LogCallRef),
+		DefinitionMap:                    make(map[DefinitionRef]*LogCallDefinition),
+		DefaultVersions:                  make(VersionSelection),
+		VersionMarkers:                   make(map[string]*regexp.Regexp),
+		linkTemplates:                    make(map[DefinitionRef]map[string]*LinkTemplate),
+		fieldFilters:                     make(map[string]*regexp.Regexp),
+	}
+	var err error
+	if v.loggerFilter, err = regexp.Compile(config.LoggerFilter); err != nil {
+		return nil, fmt.Errorf("invalid logger filter: %w", err)
+	}
+	if v.functionFilter, err = regexp.Compile(config.FunctionFilter); err != nil {
+		return nil, fmt.Errorf("invalid function filter: %w", err)
+	}
+	if config.SourceLocation != "" {
+		if v.sourceLocation, err = CompileSourceLocation(config.SourceLocation); err != nil {
+			return nil, err
+		}
+	}
+	if config.LinePrefix != "" && config.LinePrefix != LinePrefixNone && config.LinePrefix != LinePrefixAuto {
+		if v.LinePrefix, err = GetLinePrefixPreset(config.LinePrefix); err != nil {
+			return nil, err
+		}
+	}
 	hsPatterns := make([]*hs.Pattern, 0)
-	compiledAllPatternIDToLogCallMap := make(map[int]LogCallRef)
-	definitionIDToDefinitionMap := make(map[string]*LogCallDefinition)
 
-	for project, calls := range corpus {
+	// Projects are visited in order, so that the patterns and their cached database stay the same
+	for _, project := range slices.Sorted(maps.Keys(corpus)) {
+		projectCorpus := corpus[project]
 		if len(config.ProjectFilter) > 0 && !slices.Contains(config.ProjectFilter, project) {
 			continue
 		}
-		definitionsMap := make(map[string]*LogCallDefinition, 0)
-		for _, def := range calls.Definitions {
-			definitionsMap[def.ID] = &def
-		}
-		for i, call := range calls.Calls {
-			def := definitionsMap[call.DefinitionID]
-			if def.Syntax == LogCallSyntaxPrintflike {
-				parsed, err := ParsePrintfFormat(call.FormatString, getRegexGroupName(LogCallRef{
-					Project: project, CallIndex: i}))
-				if err != nil {
-					return nil, fmt.Errorf("failed to parse printf-like format string %q from %s.%d : %s", call.FormatString, project, i, err)
-				}
-				compiled, err := pcre2.CompileJIT(parsed.Regex+"$", 0, pcre2.JIT_COMPLETE)
-				if err != nil {
-					return nil, fmt.Errorf("failed to compile regex for %s: %s", parsed.Regex, err)
-				}
-				compiledRegex[LogCallRef{Project: project, CallIndex: i}] = compiled
-
-				hsPat := hs.NewPattern(parsed.HyperScanRegex+"$", 0)
-				if hsPat == nil {
-					return nil, fmt.Errorf("failed to create hyperscan pattern: %s", parsed.HyperScanRegex)
-				}
-				info, err := hsPat.Info()
-				if err != nil {
-					return nil, fmt.Errorf("failed to get hyperscan pattern info: %s", err)
-				}
-				if info.MinWidth == 0 {
-					log.Info().Msgf("Ignoring hyperscan pattern with zero width: %s from %s:%d", parsed.HyperScanRegex, call.File, call.Line)
-					continue
-				}
-				hsPatterns = append(hsPatterns, hsPat)
-				hsPat.Id = len(compiledAllPatternIDToLogCallMap) + 1
-				_, exists := compiledAllPatternIDToLogCallMap[hsPat.Id]
-				if exists {
-					return nil, fmt.Errorf("duplicate hyperscan pattern ID: %d", hsPat.Id)
-				}
-				compiledAllPatternIDToLogCallMap[hsPat.Id] = LogCallRef{
-					Project:   project,
-					CallIndex: i,
-				}
-
+		selected, fellBack, err := corpus.SelectVersion(project, config.Versions)
+		if err != nil {
+			return nil, err
+		}
+		if fellBack {
+			log.Warn().Msgf("Project %s has no version %q. Using its latest build, version %q", project, config.Versions[""], selected.Version)
+		}
+		v.DefaultVersions[project] = selected.Version
+		if config.MaxCorpusAge > 0 && time.Since(selected.BuiltAt) > config.MaxCorpusAge {
+			log.Warn().Msgf("Corpus of project %s (version %q) was built at %s, more than %s ago. Consider rebuilding it",
+				project, selected.Version, selected.BuiltAt.Format(time.DateTime), config.MaxCorpusAge)
+		}
+		files := []CorpusFile{selected}
+		if config.DetectVersion {
+			marker, err := CompileVersionMarker(selected.VersionMarker)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %w", project, err)
+			}
+			if marker == nil {
+				log.Warn().Msgf("Project %s has no version_marker. Version %q is used for all lines", project, selected.Version)
 			} else {
-				return nil, fmt.Errorf("unsupported log call syntax: %s", def.Syntax)
+				v.VersionMarkers[project] = marker
+				files = files[:0]
+				for _, version := range projectCorpus.Versions() {
+					files = append(files, projectCorpus[version])
+				}
 			}
 		}
-		for _, def := range calls.Definitions {
-			if _, ok := definitionIDToDefinitionMap[def.ID]; ok {
-				return nil, fmt.Errorf("duplicate definition ID: %s", def.ID)
+		for _, calls := range files {
+			patterns, err := v.compileCorpusFile(calls, len(hsPatterns))
+			if err != nil {
+				return nil, err
 			}
-			definitionIDToDefinitionMap[def.ID] = &def
+			hsPatterns = append(hsPatterns, patterns...)
 		}
 	}
+	if err := v.setupEnvelope(); err != nil {
+		return nil, err
+	}
 
 	db, err := buildOrLoadCachedHSPatternsDB(hsPatterns)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create hyperscan block database: %s", err)
 	}
-	return &Viewer{
-		Config:                           config,
-		Corpus:                           corpus,
-		CompiledRegex:                    compiledRegex,
-		CompiledAllRegex:                 db,
-		CompiledAllPatternIDToLogCallMap: compiledAllPatternIDToLogCallMap,
-		DefinitionIDToDefinitionMap:      definitionIDToDefinitionMap,
-	}, nil
+	v.CompiledAllRegex = db
+	v.HSDBCachePath = hsDBCachePath(hsPatterns)
+	return v, nil
+}
+
+// setupEnvelope compiles Config.EnvelopeRegex and the field filters.
+func (v *Viewer) setupEnvelope() error {
+	if v.Config.EnvelopeRegex != "" {
+		var err error
+		if v.Envelope, err = CompileLineEnvelope(v.Config.EnvelopeRegex); err != nil {
+			return err
+		}
+	}
+	fields := slices.Concat(slices.Collect(maps.Keys(v.Config.FieldFilters)), v.Config.ShowFields)
+	for _, name := range fields {
+		if v.Envelope == nil {
+			return fmt.Errorf("envelope field %s needs an envelope_regex", name)
+		}
+		if !v.Envelope.HasField(name) {
+			return fmt.Errorf("envelope_regex %q has no named group %q", v.Envelope.Regex, name)
+		}
+	}
+	for name, filter := range v.Config.FieldFilters {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return fmt.Errorf("invalid filter of field %s: %w", name, err)
+		}
+		v.fieldFilters[name] = re
+	}
+	return nil
+}
+
+// compileCorpusFile compiles the regex of every call in a corpus file, and
+// returns their Hyperscan patterns numbered after the first firstPatternID ones.
+func (v *Viewer) compileCorpusFile(calls CorpusFile, firstPatternID int) ([]*hs.Pattern, error) {
+	project := calls.Project
+	hsPatterns := make([]*hs.Pattern, 0)
+	definitionsMap := make(map[string]*LogCallDefinition, 0)
+	for _, def := range calls.Definitions {
+		definitionsMap[def.ID] = &def
+	}
+	for i, call := range calls.Calls {
+		def := definitionsMap[call.DefinitionID]
+		lcRef := LogCallRef{Project: project, Version: calls.Version, CallIndex: i}
+		if def.Syntax == LogCallSyntaxPrintflike {
+			parsed, err := ParsePrintfFormat(call.FormatString, getRegexGroupName(lcRef))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse printf-like format string %q from %s.%d : %s", call.FormatString, project, i, err)
+			}
+			compiled, err := pcre2.CompileJIT(parsed.Regex+"$", 0, pcre2.JIT_COMPLETE)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile regex for %s: %s", parsed.Regex, err)
+			}
+			v.CompiledRegex[lcRef] = compiled
+
+			hsPat := hs.NewPattern(parsed.HyperScanRegex+"$", 0)
+			if hsPat == nil {
+				return nil, fmt.Errorf("failed to create hyperscan pattern: %s", parsed.HyperScanRegex)
+			}
+			info, err := hsPat.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get hyperscan pattern info: %s", err)
+			}
+			if info.MinWidth == 0 {
+				log.Info().Msgf("Ignoring hyperscan pattern with zero width: %s from %s:%d", parsed.HyperScanRegex, call.File, call.Line)
+				continue
+			}
+			hsPatterns = append(hsPatterns, hsPat)
+			hsPat.Id = firstPatternID + len(hsPatterns)
+			_, exists := v.CompiledAllPatternIDToLogCallMap[hsPat.Id]
+			if exists {
+				return nil, fmt.Errorf("duplicate hyperscan pattern ID: %d", hsPat.Id)
+			}
+			v.CompiledAllPatternIDToLogCallMap[hsPat.Id] = lcRef
+
+		} else {
+			return nil, fmt.Errorf("unsupported log call syntax: %s", def.Syntax)
+		}
+	}
+	for _, def := range calls.Definitions {
+		ref := DefinitionRef{Project: project, Version: calls.Version, ID: def.ID}
+		if _, ok := v.DefinitionMap[ref]; ok {
+			return nil, fmt.Errorf("duplicate definition ID: %s", def.ID)
+		}
+		v.DefinitionMap[ref] = &def
+		templates := maps.Clone(def.Links)
+		if templates == nil {
+			templates = make(map[string]string)
+		}
+		templates[LinkTargetWeb] = def.LinkTemplate
+		v.linkTemplates[ref] = make(map[string]*LinkTemplate)
+		for name, template := range templates {
+			if template == "" {
+				continue
+			}
+			// Corpus files built before link templates were validated may have invalid ones
+			linkTemplate, err := ParseLinkTemplate(template, def.CustomAttrs)
+			if err != nil {
+				log.Warn().Msgf("Ignoring link %s of definition %s of project %s: %v", name, def.ID, project, err)
+				continue
+			}
+			v.linkTemplates[ref][name] = linkTemplate
+		}
+	}
+	return hsPatterns, nil
+}
+
+// DetectVersion returns the version selection in effect after line, which is
+// current unless line matches a version marker. Lines must be passed in order.
+func (v *Viewer) DetectVersion(line string, current VersionSelection) VersionSelection {
+	var updated VersionSelection
+	for project, marker := range v.VersionMarkers {
+		m := marker.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		version := m[marker.SubexpIndex("version")]
+		if version == current[project] {
+			continue
+		}
+		if _, ok := v.Corpus[project][version]; !ok {
+			log.Warn().Msgf("Detected version %q of project %s, which has no corpus. Keep using version %q", version, project, current[project])
+			continue
+		}
+		log.Debug().Msgf("Detected version %q of project %s", version, project)
+		if updated == nil {
+			updated = maps.Clone(current)
+		}
+		updated[project] = version
+	}
+	if updated == nil {
+		return current
+	}
+	return updated
 }
 
 func (v *Viewer) Close() {
@@ -251,7 +593,27 @@ func (v *Viewer) AllocScratch() (*hs.Scratch, error) {
 	return hs.NewScratch(v.CompiledAllRegex)
 }
 
-func (v *Viewer) ProcessLine(line string, scratch *hs.Scratch) (string, error) {
+// DetectLinePrefix sets LinePrefix to the preset matching the first lines of
+// the log if Config.LinePrefix is LinePrefixAuto. It must be called before
+// the lines are processed.
+func (v *Viewer) DetectLinePrefix(lines []string) {
+	if v.Config.LinePrefix != LinePrefixAuto || v.Envelope != nil {
+		return
+	}
+	v.LinePrefix = DetectLinePrefix(lines)
+	if v.LinePrefix == nil {
+		log.Info().Msgf("No known prefix detected in the first %d lines. Lines are matched as a whole", len(lines))
+		return
+	}
+	log.Info().Msgf("Detected line prefix: %s", v.LinePrefix.Name)
+}
+
+// splitPrefix splits line into the prefix to skip and the part to match
+// against the corpus, according to LinePrefix, or else StartPos or StartCharPos.
+func (v *Viewer) splitPrefix(line string) (string, string) {
+	if v.LinePrefix != nil {
+		return v.LinePrefix.Split(line)
+	}
 	startPos := 0
 	if v.Config.StartPos > 1 {
 		startPos = v.Config.StartPos - 1
@@ -267,14 +629,70 @@ func (v *Viewer) ProcessLine(line string, scratch *hs.Scratch) (string, error) {
 			cnt--
 		}
 	}
-	lineToMatch := line[min(startPos, len(line)):]
-	prefix := line[:min(startPos, len(line))]
+	return line[:min(startPos, len(line))], line[min(startPos, len(line)):]
+}
 
-	processedMatched := lineToMatch
-	refFile := ""
-	refLine := 0
-	refLink := ""
+// splitLine splits line into the text before the part to match against the
+// corpus, that part, the text after it, and the fields of Envelope.
+func (v *Viewer) splitLine(line string) (string, string, string, map[string]string) {
+	if v.Envelope != nil {
+		return v.Envelope.Split(line)
+	}
+	prefix, lineToMatch := v.splitPrefix(line)
+	return prefix, lineToMatch, "", nil
+}
+
+// acceptsFields applies the field filters of the config. Lines not matching the
+// envelope have no fields and are rejected by any filter.
+func (v *Viewer) acceptsFields(fields map[string]string) bool {
+	for name, filter := range v.fieldFilters {
+		value, ok := fields[name]
+		if !ok || !filter.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
 
+// buildFields joins the values of the envelope fields in Config.ShowFields.
+func (v *Viewer) buildFields(fields map[string]string) string {
+	res := strings.Builder{}
+	for _, name := range v.Config.ShowFields {
+		if value, ok := fields[name]; ok && value != "" {
+			res.WriteString(value)
+			res.WriteString(" ")
+		}
+	}
+	return res.String()
+}
+
+// Candidate is a log call whose regex matches a log line.
+type Candidate struct {
+	LcRef LogCallRef
+	// Start of the match in the matched part of the line
+	From int
+	// Number of characters matched by the regex
+	MatchedTotal int
+	// Number of matched characters outside of arguments
+	MatchedLiterals int
+	// Number of matched word characters outside of arguments
+	MatchedWordLiterals int
+}
+
+// BetterThan compares (MatchedWordLiterals, MatchedLiterals, MatchedTotal) of two candidates.
+func (c Candidate) BetterThan(other Candidate) bool {
+	if c.MatchedWordLiterals != other.MatchedWordLiterals {
+		return c.MatchedWordLiterals > other.MatchedWordLiterals
+	}
+	if c.MatchedLiterals != other.MatchedLiterals {
+		return c.MatchedLiterals > other.MatchedLiterals
+	}
+	return c.MatchedTotal > other.MatchedTotal
+}
+
+// FindCandidates returns all log calls of the given versions whose regex
+// matches lineToMatch, best candidate first.
+func (v *Viewer) FindCandidates(lineToMatch string, scratch *hs.Scratch, versions VersionSelection) ([]Candidate, error) {
 	type Match struct {
 		LcRef    LogCallRef
 		From, To uint64
@@ -286,6 +704,9 @@ func (v *Viewer) ProcessLine(line string, scratch *hs.Scratch) (string, error) {
 	matches := make(map[MatchKey]Match)
 	handler := hs.MatchHandler(func(id uint, from, to uint64, flags uint, context interface{}) error {
 		log.Trace().Msgf("Got hyperscan match from %d: %d-%d. LcRef: %v", id, from, to, v.CompiledAllPatternIDToLogCallMap[int(id)])
+		if lcRef := v.CompiledAllPatternIDToLogCallMap[int(id)]; lcRef.Version != versions[lcRef.Project] {
+			return nil
+		}
 		if to-from < uint64(v.Config.MinMatchChars) || to-from < uint64(v.Config.MinMatchedRatio*float64(len(lineToMatch))) {
 			return nil
 		}
@@ -305,112 +726,329 @@ func (v *Viewer) ProcessLine(line string, scratch *hs.Scratch) (string, error) {
 		return nil
 	})
 	if err := v.CompiledAllRegex.Scan([]byte(lineToMatch), scratch, handler, nil); err != nil {
-		log.Warn().Msgf("hyperscan scan failed: %s", err)
-	} else {
+		return nil, fmt.Errorf("hyperscan scan failed: %w", err)
+	}
 
-		bestMatchedLiterals := 0
-		bestMatchedTotal := 0
-		bestMatchedWordLiterals := 0
-		bestMatched := MatchKey{}
-		for key, match := range matches {
-			regex := v.CompiledRegex[match.LcRef]
-			matcher := regex.MatcherString(lineToMatch, 0)
-			defer matcher.Free()
+	candidates := make([]Candidate, 0, len(matches))
+	seen := make(map[LogCallRef]bool)
+	for _, match := range matches {
+		if seen[match.LcRef] {
+			continue
+		}
+		seen[match.LcRef] = true
+		regex := v.CompiledRegex[match.LcRef]
+		matcher := regex.MatcherString(lineToMatch, 0)
 
+		if !matcher.Matches() {
 			logCall := v.getLogCallFromRef(match.LcRef)
-			if !matcher.Matches() {
-				log.Info().Msgf("Hyperscan reported match for log call %s.%d (%s) on %s, but no match was found with %s", match.LcRef.Project, match.LcRef.CallIndex,
-					logCall.FormatString,
-					regex.Pattern,
-					lineToMatch)
-				continue
-			}
-			totalMatched := matcher.Index()[1] - matcher.Index()[0]
-			totalMatchedLiterals := totalMatched
-			totalMatchedWordLiterals := 0
-			for i := matcher.Index()[0]; i < matcher.Index()[1]; i++ {
-				if syntax.IsWordChar(rune(lineToMatch[i])) {
-					totalMatchedWordLiterals++
-				}
+			log.Info().Msgf("Hyperscan reported match for log call %s.%d (%s) on %s, but no match was found with %s", match.LcRef.Project, match.LcRef.CallIndex,
+				logCall.FormatString,
+				regex.Pattern,
+				lineToMatch)
+			matcher.Free()
+			continue
+		}
+		candidate := Candidate{LcRef: match.LcRef, From: matcher.Index()[0]}
+		candidate.MatchedTotal = matcher.Index()[1] - matcher.Index()[0]
+		candidate.MatchedLiterals = candidate.MatchedTotal
+		for i := matcher.Index()[0]; i < matcher.Index()[1]; i++ {
+			if syntax.IsWordChar(rune(lineToMatch[i])) {
+				candidate.MatchedWordLiterals++
 			}
-			log.Trace().Msgf("For %s: Total matched characters: %d", regex.Pattern, totalMatched)
-			for i := 0; i < 1000; i++ {
-				argName := fmt.Sprintf("arg%s%d", getRegexGroupName(match.LcRef), i)
-				if argRange, err := matcher.Named(argName); err == nil {
-					totalMatchedLiterals -= len(argRange)
-					for _, b := range argRange {
-						if syntax.IsWordChar(rune(b)) {
-							totalMatchedWordLiterals--
-						}
+		}
+		log.Trace().Msgf("For %s: Total matched characters: %d", regex.Pattern, candidate.MatchedTotal)
+		for i := 0; i < 1000; i++ {
+			argName := fmt.Sprintf("arg%s%d", getRegexGroupName(match.LcRef), i)
+			if argRange, err := matcher.Named(argName); err == nil {
+				candidate.MatchedLiterals -= len(argRange)
+				for _, b := range argRange {
+					if syntax.IsWordChar(rune(b)) {
+						candidate.MatchedWordLiterals--
 					}
-				} else {
-					break
 				}
+			} else {
+				break
 			}
+		}
+		matcher.Free()
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 && len(matches) > 0 {
+		log.Warn().Msgf("No pcre2 match found for line despite that Hyperscan think so: %s", lineToMatch)
+	}
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		if a.BetterThan(b) {
+			return -1
+		} else if b.BetterThan(a) {
+			return 1
+		}
+		return 0
+	})
+	return candidates, nil
+}
 
-			// Compare and update (bestMatchedWordLiterals, bestMatchedLiterals, bestMatchedTotal)
-			// with the current match
-			if totalMatchedWordLiterals > bestMatchedWordLiterals ||
-				(totalMatchedWordLiterals == bestMatchedWordLiterals && totalMatchedLiterals > bestMatchedLiterals) ||
-				(totalMatchedWordLiterals == bestMatchedWordLiterals && totalMatchedLiterals == bestMatchedLiterals && totalMatched > bestMatchedTotal) {
-				bestMatched = key
-				bestMatchedWordLiterals = totalMatchedWordLiterals
-				bestMatchedLiterals = totalMatchedLiterals
-				bestMatchedTotal = totalMatched
-			}
+// IsConfident reports whether a candidate passes the minimum match thresholds
+// of the config for a line of lineLen characters.
+func (v *Viewer) IsConfident(c Candidate, lineLen int) bool {
+	return c.MatchedLiterals >= v.Config.MinMatchChars && c.MatchedWordLiterals >= v.Config.MinMatchWordChars &&
+		float64(c.MatchedTotal) >= v.Config.MinMatchedRatio*float64(lineLen)
+}
+
+// preferLevel returns the first candidate tied with the best one whose level
+// agrees with the level of the line, or the best one if there is none. The
+// level is the level field of the envelope if captured, or else detected in
+// the header, i.e. all text before the match of the candidate.
+func (v *Viewer) preferLevel(candidates []Candidate, prefix string, lineToMatch string, fields map[string]string) Candidate {
+	best := candidates[0]
+	for _, c := range candidates {
+		if best.BetterThan(c) {
+			break
+		}
+		lineLevel := NormalizeLogLevel(fields["level"])
+		if _, ok := fields["level"]; !ok {
+			lineLevel = DetectLineLevel(prefix + lineToMatch[:c.From])
+		}
+		if lineLevel != "" && v.getLogCallFromRef(c.LcRef).NormalizedLevel() == lineLevel {
+			return c
+		}
+	}
+	return best
+}
 
+// extractSourceLocation returns the source location logged in line, from the
+// file and line fields of the envelope or else by Config.SourceLocation.
+// Formats looked for outside the message only search prefix and suffix.
+func (v *Viewer) extractSourceLocation(line string, prefix string, suffix string, fields map[string]string) (string, int, bool) {
+	if file, ok := fields[sourceLocationFileGroup]; ok {
+		if file, lineNo, ok := parseSourceLocation(file, fields[sourceLocationLineGroup]); ok {
+			return file, lineNo, true
 		}
-		if bestMatchedTotal == 0 {
-			if len(matches) > 0 {
-				log.Warn().Msgf("No pcre2 match found for line despite that Hyperscan think so: %s", lineToMatch)
+	}
+	if v.sourceLocation == nil {
+		return "", 0, false
+	}
+	if !v.sourceLocation.OutsideMessage {
+		return v.sourceLocation.Extract(line)
+	}
+	if file, lineNo, ok := v.sourceLocation.Extract(prefix); ok {
+		return file, lineNo, true
+	}
+	return v.sourceLocation.Extract(suffix)
+}
+
+// pinSourceLocation returns the first candidate whose call spans the source
+// location logged in the line, and whether there is one.
+func (v *Viewer) pinSourceLocation(candidates []Candidate, file string, line int) (Candidate, bool) {
+	for _, c := range candidates {
+		if v.getLogCallFromRef(c.LcRef).spansSourceLocation(file, line) {
+			return c, true
+		}
+	}
+	return Candidate{}, false
+}
+
+// acceptsCall applies the level, logger and function filters of the config.
+func (v *Viewer) acceptsCall(call *LogCall) bool {
+	if len(v.Config.LevelFilter) > 0 && !slices.Contains(v.Config.LevelFilter, call.NormalizedLevel()) {
+		return false
+	}
+	if v.Config.LoggerFilter != "" && !v.loggerFilter.MatchString(call.Logger) {
+		return false
+	}
+	return v.Config.FunctionFilter == "" || v.functionFilter.MatchString(call.Function)
+}
+
+// buildLinks returns the URL of each link target of a call of corpusFile.
+func (v *Viewer) buildLinks(call *LogCall, definitionRef DefinitionRef, corpusFile *CorpusFile) map[string]string {
+	links := make(map[string]string)
+	if templates := v.linkTemplates[definitionRef]; len(templates) > 0 {
+		vars := linkVariables(call, v.DefinitionMap[definitionRef], corpusFile)
+		for name, template := range templates {
+			links[name] = template.Expand(vars)
+		}
+	}
+	if v.Config.Editor != "" {
+		sourceRoot, ok := v.Config.SourceRoots[call.Project]
+		if !ok {
+			sourceRoot, ok = v.Config.SourceRoots[""]
+		}
+		if !ok {
+			sourceRoot = corpusFile.Provenance.RepoRoot
+		}
+		if sourceRoot != "" {
+			links[LinkTargetEditor] = editorLinks[v.Config.Editor](localSourcePath(sourceRoot, call.File), call.Line, call.Column)
+		}
+	}
+	return links
+}
+
+// buildCallInfo describes the ID, level, logger and enclosing function of a call.
+func buildCallInfo(call *LogCall) string {
+	info := []string{}
+	if call.ID != "" {
+		info = append(info, "id="+call.ID)
+	}
+	if level := call.Level; level != "" {
+		info = append(info, "level="+level)
+	} else if level := call.NormalizedLevel(); level != "" {
+		info = append(info, "level="+level)
+	}
+	if call.Logger != "" {
+		info = append(info, "logger="+call.Logger)
+	}
+	if call.Function != "" {
+		info = append(info, "func="+call.Function)
+	}
+	if len(info) == 0 {
+		return ""
+	}
+	return "  [" + strings.Join(info, " ") + "]"
+}
+
+// buildBlameInfo describes the last commit that changed a call.
+func buildBlameInfo(call *LogCall) string {
+	if call.Blame == nil {
+		return ""
+	}
+	return fmt.Sprintf("  [%.10s %s %s %q]", call.Blame.Commit, call.Blame.Author, call.Blame.Date.Format(time.DateOnly), call.Blame.Subject)
+}
+
+// buildSnippet returns the source lines of call within Config.Context lines of
+// it, each on a line of its own below the ref column. The lines of the call
+// itself are marked with '>'.
+func (v *Viewer) buildSnippet(call *LogCall) string {
+	if call.Snippet == nil {
+		return ""
+	}
+	output := termenv.NewOutput(os.Stdout)
+	emptyRefColumn := v.buildRefColumn("", 0, "")
+	endLine := max(call.EndLine, call.Line)
+	res := strings.Builder{}
+	for i, content := range call.Snippet.Lines {
+		lineNo := call.Snippet.StartLine + i
+		if lineNo < call.Line-v.Config.Context || lineNo > endLine+v.Config.Context {
+			continue
+		}
+		marker := " "
+		if lineNo >= call.Line && lineNo <= endLine {
+			marker = ">"
+		}
+		res.WriteString("\n")
+		res.WriteString(emptyRefColumn)
+		res.WriteString(output.String(fmt.Sprintf("%s%6d  %s", marker, lineNo, content)).Foreground(output.Color("#888888")).String())
+	}
+	return res.String()
+}
+
+// ProcessLine annotates a single log line. Only calls of the given versions are
+// matched. A nil versions selects v.DefaultVersions.
+func (v *Viewer) ProcessLine(line string, scratch *hs.Scratch, versions VersionSelection) (string, error) {
+	if versions == nil {
+		versions = v.DefaultVersions
+	}
+	prefix, lineToMatch, suffix, fields := v.splitLine(line)
+	if len(v.fieldFilters) > 0 && !v.acceptsFields(fields) {
+		return "", ErrFilteredOut
+	}
+	linePrefix, lineSuffix := prefix, suffix
+	if len(v.Config.ShowFields) > 0 {
+		prefix, suffix = v.buildFields(fields), ""
+	}
+
+	processedMatched := lineToMatch
+	refFile := ""
+	refLine := 0
+	refLink := ""
+	callInfo := ""
+	extraLinks := ""
+	snippet := ""
+	filteredOut := v.Config.HasCallFilter()
+
+	candidates, err := v.FindCandidates(lineToMatch, scratch, versions)
+	if err != nil {
+		log.Warn().Msgf("%s", err)
+	} else if len(candidates) > 0 && v.IsConfident(candidates[0], len(lineToMatch)) {
+		loggedFile, loggedLine, hasLocation := v.extractSourceLocation(line, linePrefix, lineSuffix, fields)
+		bestMatchedRecord, pinned := Candidate{}, false
+		if hasLocation {
+			bestMatchedRecord, pinned = v.pinSourceLocation(candidates, loggedFile, loggedLine)
+		}
+		if !pinned {
+			bestMatchedRecord = v.preferLevel(candidates, prefix, lineToMatch, fields)
+		}
+		logCall := v.getLogCallFromRef(bestMatchedRecord.LcRef)
+		if filteredOut && !v.acceptsCall(logCall) {
+			return "", ErrFilteredOut
+		}
+		filteredOut = false
+		output := termenv.NewOutput(os.Stdout)
+		// This line is a match!
+		refFile = logCall.File
+		refLine = logCall.Line
+		definitionRef := DefinitionRef{
+			Project: bestMatchedRecord.LcRef.Project,
+			Version: bestMatchedRecord.LcRef.Version,
+			ID:      logCall.DefinitionID,
+		}
+		corpusFile := v.Corpus[bestMatchedRecord.LcRef.Project][bestMatchedRecord.LcRef.Version]
+		links := v.buildLinks(logCall, definitionRef, &corpusFile)
+		for i, target := range v.Config.linkTargets() {
+			if i == 0 {
+				refLink = links[target]
+			} else if links[target] != "" {
+				extraLinks += " " + termenv.Hyperlink(links[target], output.String("["+target+"]").Faint().String())
 			}
-		} else {
-			bestMatchedRecord := matches[bestMatched]
-			logCall := v.getLogCallFromRef(bestMatchedRecord.LcRef)
-			if bestMatchedLiterals >= v.Config.MinMatchChars && bestMatchedWordLiterals >= v.Config.MinMatchWordChars && float64(bestMatchedTotal) >= v.Config.MinMatchedRatio*float64(len(lineToMatch)) {
-				output := termenv.NewOutput(os.Stdout)
-				// This line is a match!
-				refFile = logCall.File
-				refLine = logCall.Line
-				definition := v.DefinitionIDToDefinitionMap[logCall.DefinitionID]
-				refLink = strings.ReplaceAll(definition.LinkTemplate, "{file}", refFile)
-				refLink = strings.ReplaceAll(refLink, "{line}", strconv.Itoa(refLine))
-				if !v.Config.SkipPrintArgumentExpr {
-					processedMatchedBuilder := strings.Builder{}
-					regex := v.CompiledRegex[bestMatchedRecord.LcRef]
-					// Very ugly hack, matcher.Named() only returns a byteSlice and didn't
-					// contain the start and end indices of the match. We have to recover it
-					// using byte slice cap
-					lineToMatchBytes := []byte(lineToMatch)
-					matcher := regex.Matcher(lineToMatchBytes, 0)
-					defer matcher.Free()
-					prevEnd := matcher.Index()[0]
-					processedMatchedBuilder.WriteString(lineToMatch[:prevEnd])
-					for i := 0; i < 1000; i++ {
-						argName := fmt.Sprintf("arg%s%d", getRegexGroupName(bestMatchedRecord.LcRef), i)
-						if argRange, err := matcher.Named(argName); err == nil {
-							argStartPos := cap(lineToMatchBytes) - cap(argRange)
-							argEndPos := argStartPos + len(argRange)
-							if argStartPos < 0 || argStartPos < prevEnd || argEndPos >= len(lineToMatch)+1 {
-								log.Panic().Msgf("Invalid PCRE2 match range: %v. Cap(range): %d. Cap(lineToMatch): %d", argRange, cap(argRange), cap(lineToMatchBytes))
-							}
-							processedMatchedBuilder.WriteString(lineToMatch[prevEnd:argStartPos])
-							argExpr := strings.ReplaceAll(logCall.ArgumentExprs[i], "\n", "\\n")
-							processedMatchedBuilder.WriteString(output.String("|" + argExpr + "|").Foreground(output.Color("#006633")).Background(output.Color("#202020")).String())
-							processedMatchedBuilder.WriteString(lineToMatch[argStartPos:argEndPos])
-							prevEnd = argEndPos
-						} else {
-							break
-						}
+		}
+		if !v.Config.SkipPrintArgumentExpr {
+			processedMatchedBuilder := strings.Builder{}
+			regex := v.CompiledRegex[bestMatchedRecord.LcRef]
+			// Very ugly hack, matcher.Named() only returns a byteSlice and didn't
+			// contain the start and end indices of the match. We have to recover it
+			// using byte slice cap
+			lineToMatchBytes := []byte(lineToMatch)
+			matcher := regex.Matcher(lineToMatchBytes, 0)
+			defer matcher.Free()
+			prevEnd := matcher.Index()[0]
+			processedMatchedBuilder.WriteString(lineToMatch[:prevEnd])
+			for i := 0; i < 1000; i++ {
+				argName := fmt.Sprintf("arg%s%d", getRegexGroupName(bestMatchedRecord.LcRef), i)
+				if argRange, err := matcher.Named(argName); err == nil {
+					argStartPos := cap(lineToMatchBytes) - cap(argRange)
+					argEndPos := argStartPos + len(argRange)
+					if argStartPos < 0 || argStartPos < prevEnd || argEndPos >= len(lineToMatch)+1 {
+						log.Panic().Msgf("Invalid PCRE2 match range: %v. Cap(range): %d. Cap(lineToMatch): %d", argRange, cap(argRange), cap(lineToMatchBytes))
 					}
-					processedMatchedBuilder.WriteString(lineToMatch[prevEnd:])
-					processedMatched = processedMatchedBuilder.String()
+					processedMatchedBuilder.WriteString(lineToMatch[prevEnd:argStartPos])
+					argExpr := strings.ReplaceAll(logCall.ArgumentExprs[i], "\n", "\\n")
+					processedMatchedBuilder.WriteString(output.String("|" + argExpr + "|").Foreground(output.Color("#006633")).Background(output.Color("#202020")).String())
+					processedMatchedBuilder.WriteString(lineToMatch[argStartPos:argEndPos])
+					prevEnd = argEndPos
+				} else {
+					break
 				}
 			}
+			processedMatchedBuilder.WriteString(lineToMatch[prevEnd:])
+			processedMatched = processedMatchedBuilder.String()
+		}
+		if hasLocation && !pinned {
+			// The text matches a call elsewhere, usually because the corpus is older than the logging binary
+			callInfo += output.String(fmt.Sprintf("  [logged at %s:%d, corpus may be stale]", loggedFile, loggedLine)).Foreground(output.Color("#cc6600")).String()
+		}
+		if v.Config.ShowCallInfo {
+			callInfo += output.String(buildCallInfo(logCall)).Faint().String()
+		}
+		if v.Config.ShowBlame {
+			callInfo += output.String(buildBlameInfo(logCall)).Faint().String()
+		}
+		if v.Config.Context > 0 {
+			snippet = v.buildSnippet(logCall)
 		}
 	}
 
+	if filteredOut {
+		return "", ErrFilteredOut
+	}
 	refColumn := v.buildRefColumn(refFile, refLine, refLink)
 
-	return fmt.Sprintf("%s%s%s", refColumn, prefix, processedMatched), nil
+	return fmt.Sprintf("%s%s%s%s%s%s%s", refColumn, prefix, processedMatched, suffix, callInfo, extraLinks, snippet), nil
 }
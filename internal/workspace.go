@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/phuslu/log"
+)
+
+// subtreeConfig is a logcall definition file that applies to all files under
+// Root, except the ones under a nested definition file.
+type subtreeConfig struct {
+	LogCallDefinitionFile
+	// Repo-relative directory of the definition file. Empty for the repo root.
+	Root string
+	// Definitions of the enclosing definition file, if InheritDefinitions is set
+	inherited []LogCallDefinition
+	// Content of the definition file
+	data []byte
+
+	sourceRegex       *regexp.Regexp
+	ignoreSourceRegex *regexp.Regexp
+}
+
+func readSubtreeConfig(tree SourceTree, root string) (*subtreeConfig, error) {
+	cfg := &subtreeConfig{
+		LogCallDefinitionFile: LogCallDefinitionFile{
+			Definitions: make([]LogCallDefinition, 0),
+		},
+		Root: root,
+	}
+	filePath := path.Join(root, LogCallDefinitionFileName)
+	data, err := tree.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading logcall definition file %s: %w", filePath, err)
+	}
+	if err := toml.Unmarshal(data, &cfg.LogCallDefinitionFile); err != nil {
+		return nil, fmt.Errorf("error unmarshalling logcall definition file %s: %w", filePath, err)
+	}
+	cfg.data = data
+	if cfg.Project != "" {
+		if err := ValidateProjectName(cfg.Project); err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
+	if _, err := CompileVersionMarker(cfg.VersionMarker); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	if err := cfg.View.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: [view]: %w", filePath, err)
+	}
+	if _, ok := linkStyleTemplates[cfg.LinkStyle]; !ok && cfg.LinkStyle != "" && cfg.LinkStyle != LinkStyleNone {
+		return nil, fmt.Errorf("%s: unknown link_style %q", filePath, cfg.LinkStyle)
+	}
+	if cfg.sourceRegex, err = regexp.Compile(cfg.SourceRegex); err != nil {
+		return nil, fmt.Errorf("error compiling source regex of %s: %w", filePath, err)
+	}
+	if cfg.ignoreSourceRegex, err = regexp.Compile(cfg.IgnoreSourceRegex); err != nil {
+		return nil, fmt.Errorf("error compiling ignore source regex of %s: %w", filePath, err)
+	}
+	for i := range cfg.Definitions {
+		if err = cfg.Definitions[i].Compile(); err != nil {
+			cfg.Close()
+			return nil, fmt.Errorf("invalid log call definition in %s: %w", filePath, err)
+		}
+	}
+	return cfg, nil
+}
+
+// contains reports whether filePath is under the root of the config.
+func (c *subtreeConfig) contains(filePath string) bool {
+	return c.Root == "" || strings.HasPrefix(filePath, c.Root+"/")
+}
+
+// relPath returns filePath relative to the root of the config.
+func (c *subtreeConfig) relPath(filePath string) string {
+	if c.Root == "" {
+		return filePath
+	}
+	return strings.TrimPrefix(filePath, c.Root+"/")
+}
+
+// matches applies source_regex and ignore_source_regex to filePath,
+// relative to the root of the config.
+func (c *subtreeConfig) matches(filePath string) bool {
+	relPath := c.relPath(filePath)
+	if c.IgnoreSourceRegex != "" && c.ignoreSourceRegex.MatchString(relPath) {
+		return false
+	}
+	return c.SourceRegex == "" || c.sourceRegex.MatchString(relPath)
+}
+
+// definitions returns both own and inherited definitions.
+func (c *subtreeConfig) definitions() []LogCallDefinition {
+	return append(slices.Clip(c.Definitions), c.inherited...)
+}
+
+func rootDepth(root string) int {
+	if root == "" {
+		return 0
+	}
+	return strings.Count(root, "/") + 1
+}
+
+// innermostConfig returns the config with the deepest root containing filePath.
+// configs must be ordered from outermost to innermost.
+func innermostConfig(configs []*subtreeConfig, filePath string) *subtreeConfig {
+	for i := len(configs) - 1; i >= 0; i-- {
+		if configs[i].contains(filePath) {
+			return configs[i]
+		}
+	}
+	return nil
+}
+
+func closeSubtreeConfigs(configs []*subtreeConfig) {
+	for _, cfg := range configs {
+		cfg.Close()
+	}
+}
+
+// loadWorkspaceConfigs reads the definition file at the repo root, plus every
+// nested definition file listed in its roots or discovered in allFiles. The
+// returned configs are ordered from outermost to innermost.
+func loadWorkspaceConfigs(tree SourceTree, allFiles []string) ([]*subtreeConfig, error) {
+	rootCfg, err := readSubtreeConfig(tree, "")
+	if err != nil {
+		return nil, err
+	}
+	if rootCfg.Project == "" {
+		rootCfg.Close()
+		return nil, fmt.Errorf("project is not set in %s", LogCallDefinitionFileName)
+	}
+	configs := []*subtreeConfig{rootCfg}
+	loaded := map[string]bool{"": true}
+	pending := []string{}
+	existing := make(map[string]bool, len(allFiles))
+	for _, filePath := range allFiles {
+		existing[filePath] = true
+	}
+	// Roots are confined to the repo, and must have a definition file
+	queueRoots := func(cfg *subtreeConfig) error {
+		for _, root := range cfg.Roots {
+			if cleaned := path.Clean(root); path.IsAbs(root) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+				return fmt.Errorf("root %q of %s is outside of its subtree", root, path.Join(cfg.Root, LogCallDefinitionFileName))
+			}
+			nested := path.Join(cfg.Root, root)
+			if nested != "." && !existing[path.Join(nested, LogCallDefinitionFileName)] {
+				return fmt.Errorf("root %q of %s has no %s", root, path.Join(cfg.Root, LogCallDefinitionFileName), LogCallDefinitionFileName)
+			}
+			pending = append(pending, nested)
+		}
+		return nil
+	}
+	if err := queueRoots(rootCfg); err != nil {
+		rootCfg.Close()
+		return nil, err
+	}
+	if rootCfg.DiscoverNested {
+		for _, filePath := range allFiles {
+			if path.Base(filePath) == LogCallDefinitionFileName && filePath != LogCallDefinitionFileName {
+				pending = append(pending, path.Dir(filePath))
+			}
+		}
+	}
+	for len(pending) > 0 {
+		root := pending[0]
+		pending = pending[1:]
+		if root == "." || loaded[root] {
+			continue
+		}
+		loaded[root] = true
+		log.Debug().Msgf("Loading nested logcall definition file at %s", root)
+		cfg, err := readSubtreeConfig(tree, root)
+		if err != nil {
+			closeSubtreeConfigs(configs)
+			return nil, err
+		}
+		configs = append(configs, cfg)
+		if err := queueRoots(cfg); err != nil {
+			closeSubtreeConfigs(configs)
+			return nil, err
+		}
+	}
+
+	// Parents are resolved before their children. Roots of the same depth are
+	// ordered by path, so that the merged configs don't depend on discovery order
+	slices.SortStableFunc(configs, func(a, b *subtreeConfig) int {
+		if c := rootDepth(a.Root) - rootDepth(b.Root); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Root, b.Root)
+	})
+	for i, cfg := range configs[1:] {
+		parent := innermostConfig(configs[:i+1], cfg.Root+"/")
+		if cfg.Project == "" {
+			cfg.Project = parent.Project
+		}
+		if cfg.InheritDefinitions {
+			cfg.inherited = parent.definitions()
+		}
+	}
+	return configs, nil
+}
+
+// mergeProjectDefinitions returns the definitions of all configs of a project.
+// Definitions with the same ID must be identical.
+func mergeProjectDefinitions(configs []*subtreeConfig) ([]LogCallDefinition, error) {
+	definitions := []LogCallDefinition{}
+	seen := make(map[string][]byte)
+	for _, cfg := range configs {
+		for _, def := range cfg.definitions() {
+			data, err := json.Marshal(def)
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling log call definition %s: %w", def.ID, err)
+			}
+			if prev, ok := seen[def.ID]; ok {
+				if string(prev) != string(data) {
+					return nil, fmt.Errorf("conflicting log call definitions with ID %s in project %s", def.ID, cfg.Project)
+				}
+				continue
+			}
+			seen[def.ID] = data
+			definitions = append(definitions, def)
+		}
+	}
+	return definitions, nil
+}
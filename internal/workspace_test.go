@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testDefinitionFile returns a definition file with a C definition for each ID.
+func testDefinitionFile(header string, ids ...string) string {
+	content := header + "\n"
+	for _, id := range ids {
+		content += fmt.Sprintf("[[definitions]]\nid = %q\nlanguage = \"c\"\nquery = '(call_expression function: (identifier) @method)'\n", id)
+	}
+	return content
+}
+
+// describeConfigs summarizes each config as "<root>:<project>:<definition IDs>".
+func describeConfigs(configs []*subtreeConfig) []string {
+	descs := []string{}
+	for _, cfg := range configs {
+		ids := []string{}
+		for _, def := range cfg.definitions() {
+			ids = append(ids, def.ID)
+		}
+		descs = append(descs, fmt.Sprintf("%s:%s:%s", cfg.Root, cfg.Project, strings.Join(ids, ",")))
+	}
+	return descs
+}
+
+func TestLoadWorkspaceConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "single definition file",
+			files: map[string]string{".logalign.toml": testDefinitionFile(`project = "app"`, "printf")},
+			want:  []string{":app:printf"},
+		},
+		{
+			name: "roots ordered by depth, then path",
+			files: map[string]string{
+				".logalign.toml":          testDefinitionFile("project = \"mono\"\nroots = ['b', 'a/x', 'a', '.']", "root"),
+				"a/.logalign.toml":        testDefinitionFile("", "a"),
+				"a/x/.logalign.toml":      testDefinitionFile("", "x"),
+				"b/.logalign.toml":        testDefinitionFile("", "b"),
+				"unlisted/.logalign.toml": testDefinitionFile("", "unlisted"),
+			},
+			want: []string{":mono:root", "a:mono:a", "b:mono:b", "a/x:mono:x"},
+		},
+		{
+			name: "roots of nested definition files",
+			files: map[string]string{
+				".logalign.toml":     testDefinitionFile("project = \"mono\"\nroots = ['a']", "root"),
+				"a/.logalign.toml":   testDefinitionFile("roots = ['x']", "a"),
+				"a/x/.logalign.toml": testDefinitionFile("", "x"),
+			},
+			want: []string{":mono:root", "a:mono:a", "a/x:mono:x"},
+		},
+		{
+			name: "discovered nested definition files",
+			files: map[string]string{
+				".logalign.toml":     testDefinitionFile("project = \"mono\"\ndiscover_nested = true", "root"),
+				"z/.logalign.toml":   testDefinitionFile("", "z"),
+				"a/.logalign.toml":   testDefinitionFile("", "a"),
+				"a/x/.logalign.toml": testDefinitionFile("", "x"),
+				"a/x/main.c":         "",
+			},
+			want: []string{":mono:root", "a:mono:a", "z:mono:z", "a/x:mono:x"},
+		},
+		{
+			name: "projects inherited from the innermost parent",
+			files: map[string]string{
+				".logalign.toml":     testDefinitionFile("project = \"mono\"\ndiscover_nested = true", "root"),
+				"a/.logalign.toml":   testDefinitionFile(`project = "alpha"`, "a"),
+				"a/x/.logalign.toml": testDefinitionFile("", "x"),
+				"ab/.logalign.toml":  testDefinitionFile("", "ab"),
+			},
+			want: []string{":mono:root", "a:alpha:a", "ab:mono:ab", "a/x:alpha:x"},
+		},
+		{
+			name: "inherited definitions",
+			files: map[string]string{
+				".logalign.toml":     testDefinitionFile("project = \"mono\"\ndiscover_nested = true", "root"),
+				"a/.logalign.toml":   testDefinitionFile("inherit_definitions = true", "a"),
+				"a/x/.logalign.toml": testDefinitionFile("inherit_definitions = true", "x"),
+				"b/.logalign.toml":   testDefinitionFile("", "b"),
+			},
+			want: []string{":mono:root", "a:mono:a,root", "b:mono:b", "a/x:mono:x,a,root"},
+		},
+		{
+			name:    "no project",
+			files:   map[string]string{".logalign.toml": testDefinitionFile("", "printf")},
+			wantErr: "project is not set",
+		},
+		{
+			name:    "absolute root",
+			files:   map[string]string{".logalign.toml": testDefinitionFile("project = \"mono\"\nroots = ['/etc']")},
+			wantErr: "outside of its subtree",
+		},
+		{
+			name: "root outside of the subtree",
+			files: map[string]string{
+				".logalign.toml":   testDefinitionFile("project = \"mono\"\nroots = ['a']"),
+				"a/.logalign.toml": testDefinitionFile("roots = ['../b']"),
+				"b/.logalign.toml": testDefinitionFile(""),
+			},
+			wantErr: "outside of its subtree",
+		},
+		{
+			name:    "root without definition file",
+			files:   map[string]string{".logalign.toml": testDefinitionFile("project = \"mono\"\nroots = ['a']"), "a/main.c": ""},
+			wantErr: "has no .logalign.toml",
+		},
+		{
+			name: "invalid project of a nested definition file",
+			files: map[string]string{
+				".logalign.toml":   testDefinitionFile("project = \"mono\"\nroots = ['a']"),
+				"a/.logalign.toml": testDefinitionFile(`project = "a@b"`),
+			},
+			wantErr: "invalid project name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := &fakeSourceTree{files: tt.files}
+			allFiles, err := tree.ListFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			configs, err := loadWorkspaceConfigs(tree, allFiles)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					closeSubtreeConfigs(configs)
+					t.Fatalf("loadWorkspaceConfigs error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadWorkspaceConfigs: %v", err)
+			}
+			defer closeSubtreeConfigs(configs)
+			if got := describeConfigs(configs); !slices.Equal(got, tt.want) {
+				t.Errorf("configs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInnermostConfig(t *testing.T) {
+	configs := []*subtreeConfig{{Root: ""}, {Root: "a"}, {Root: "ab"}, {Root: "a/x"}}
+	tests := []struct {
+		filePath string
+		want     string
+	}{
+		{"main.c", ""},
+		{"a/main.c", "a"},
+		{"ab/main.c", "ab"},
+		{"a/x/y/main.c", "a/x"},
+		{"a/xy/main.c", "a"},
+	}
+	for _, tt := range tests {
+		if got := innermostConfig(configs, tt.filePath); got.Root != tt.want {
+			t.Errorf("innermostConfig(%q) = %q, want %q", tt.filePath, got.Root, tt.want)
+		}
+	}
+}